@@ -2,21 +2,23 @@ package casbinbunadapter
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"log"
 	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
 
 	"github.com/casbin/casbin/v2/model"
 	"github.com/casbin/casbin/v2/persist"
-	_ "github.com/denisenkom/go-mssqldb"
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/uptrace/bun"
-	"github.com/uptrace/bun/dialect/mssqldialect"
-	"github.com/uptrace/bun/dialect/mysqldialect"
-	"github.com/uptrace/bun/dialect/pgdialect"
-	"github.com/uptrace/bun/dialect/sqlitedialect"
-	"github.com/uptrace/bun/driver/pgdriver"
-	"github.com/uptrace/bun/driver/sqliteshim"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/dialect/feature"
 	"github.com/uptrace/bun/extra/bundebug"
 )
 
@@ -27,11 +29,45 @@ var (
 	_ persist.BatchAdapter = (*bunAdapter)(nil)
 	// check if the bunAdapter implements the UpdatableAdapter interface
 	_ persist.UpdatableAdapter = (*bunAdapter)(nil)
+	// check if the bunAdapter implements the FilteredAdapter interface
+	_ persist.FilteredAdapter = (*bunAdapter)(nil)
+)
+
+const (
+	defaultTableName = "casbin_policies"
+	tableAlias       = "cp"
+)
+
+const (
+	defaultBatchSize    = 1000
+	mssqlMaxInsertRows  = 1000
+	sqliteMaxInsertRows = 500
 )
 
 type bunAdapter struct {
-	db        *bun.DB
-	debugMode bool
+	db               *bun.DB
+	debugMode        bool
+	tableName        string
+	fieldCount       int
+	columnType       string
+	columnLength     int
+	hashRules        bool
+	ignoreDuplicates bool
+	removeDuplicates bool
+	strictMatch      bool
+	incrementalSave  bool
+	batchSize        int
+	pageSize         int
+	ownsDB           bool
+	retryPolicy      RetryPolicy
+	skipTable        bool
+	isFiltered       atomic.Bool
+	audit            bool
+	history          bool
+	snapshots        bool
+	changeLog        bool
+	watcherMu        sync.RWMutex
+	watcher          changeRecorder
 }
 
 type adapterOption func(*bunAdapter)
@@ -42,6 +78,125 @@ func WithDebugMode() adapterOption {
 	}
 }
 
+// WithIgnoreDuplicates makes the adapter ignore the policy rules which already exist in the storage when adding them.
+// Without this option, adding an existing policy rule returns ErrDuplicatePolicy.
+func WithIgnoreDuplicates() adapterOption {
+	return func(a *bunAdapter) {
+		a.ignoreDuplicates = true
+	}
+}
+
+// WithRemoveDuplicates makes the adapter remove the rows which duplicate an older row of the same policy rule
+// when it creates the unique index on a table which has none. Without this option, such rows fail NewAdapter with ErrDuplicatePolicy.
+func WithRemoveDuplicates() adapterOption {
+	return func(a *bunAdapter) {
+		a.removeDuplicates = true
+	}
+}
+
+// WithStrictMatch makes RemovePolicy, RemovePolicies, UpdatePolicy and UpdatePolicies fail with ErrPolicyNotFound
+// when no row matches a rule, and roll back the whole batch of RemovePolicies and UpdatePolicies.
+// Without this option, the rules which do not exist are skipped, and the WithResult variants report the rows affected.
+func WithStrictMatch() adapterOption {
+	return func(a *bunAdapter) {
+		a.strictMatch = true
+	}
+}
+
+// WithIncrementalSave makes SavePolicy compare the model with the stored policies
+// and only insert and delete the difference, instead of rewriting the whole table.
+// The unchanged rows keep their ids.
+func WithIncrementalSave() adapterOption {
+	return func(a *bunAdapter) {
+		a.incrementalSave = true
+	}
+}
+
+// WithBatchSize sets the maximum number of the rows written by a single statement,
+// which otherwise depends on the dialect. A size smaller than one is ignored.
+func WithBatchSize(batchSize int) adapterOption {
+	return func(a *bunAdapter) {
+		if batchSize > 0 {
+			a.batchSize = batchSize
+		}
+	}
+}
+
+// WithPageSize makes LoadPolicy and LoadFilteredPolicy select the policies in pages of pageSize rows,
+// instead of reading all of them with a single query. A size smaller than one is ignored.
+func WithPageSize(pageSize int) adapterOption {
+	return func(a *bunAdapter) {
+		if pageSize > 0 {
+			a.pageSize = pageSize
+		}
+	}
+}
+
+// WithOwnership makes the adapter close the database passed to NewAdapterWithSqlDB or NewAdapterWithBunDB on Close.
+// The database opened by NewAdapter is always closed on Close.
+func WithOwnership() adapterOption {
+	return func(a *bunAdapter) {
+		a.ownsDB = true
+	}
+}
+
+// WithSkipTableCreation makes the adapter skip creating and migrating the table,
+// so that it works with a database user granted only SELECT, INSERT, UPDATE and DELETE.
+// The table must be created in advance, for example with the statements returned by SchemaSQL.
+// The hash column and the lengths of the value columns are still read from the existing table.
+func WithSkipTableCreation() adapterOption {
+	return func(a *bunAdapter) {
+		a.skipTable = true
+	}
+}
+
+// WithFieldCount sets the number of the value columns (v0, v1, ...) of the table,
+// so that policy rules with more than six values can be stored.
+// The columns from v6 are added to the table when they do not exist.
+// A count smaller than the default of six is ignored.
+func WithFieldCount(fieldCount int) adapterOption {
+	return func(a *bunAdapter) {
+		if fieldCount > defaultFieldCount {
+			a.fieldCount = fieldCount
+		}
+	}
+}
+
+// WithColumnLength sets the length of the varchar value columns, which is 100 by default.
+// The shorter columns of an existing table are widened to the length, but the longer ones are never narrowed.
+// The values longer than the length are rejected with ValueTooLongError before they are written.
+func WithColumnLength(length int) adapterOption {
+	return func(a *bunAdapter) {
+		if length > 0 {
+			a.columnType = fmt.Sprintf("varchar(%d)", length)
+			a.columnLength = length
+		}
+	}
+}
+
+// WithColumnType sets the SQL type of the value columns, such as "text" or "nvarchar(max)",
+// and the maximum length of the values in characters, which is not checked when it is zero.
+// The columns of an existing table which are shorter than the type are altered to the type,
+// and the values are checked against the shortest column when it is shorter than maxLength.
+func WithColumnType(columnType string, maxLength int) adapterOption {
+	return func(a *bunAdapter) {
+		if columnType != "" {
+			a.columnType = columnType
+			a.columnLength = maxLength
+		}
+	}
+}
+
+// WithTableName sets the name of the table which stores the policies.
+// The default table name is "casbin_policies". An empty name is ignored.
+func WithTableName(tableName string) adapterOption {
+	return func(a *bunAdapter) {
+		if tableName != "" {
+			a.tableName = tableName
+		}
+	}
+}
+
 func NewAdapter(driverName, dataSourceName string, opts ...adapterOption) (*bunAdapter, error) {
 	sqlDB, err := openSqlDB(driverName, dataSourceName)
 	if err != nil {
@@ -50,17 +205,22 @@ func NewAdapter(driverName, dataSourceName string, opts ...adapterOption) (*bunA
 
 	db, err := openBunDB(sqlDB, driverName)
 	if err != nil {
+		_ = sqlDB.Close()
 		return nil, err
 	}
 
-	b, err := newAdapter(db, opts...)
+	// the adapter owns the database it opened, and the option is appended to a copy, leaving the backing array of the caller untouched
+	b, err := newAdapter(db, append(slices.Clip(opts), WithOwnership())...)
 	if err != nil {
+		_ = db.Close()
 		return nil, err
 	}
 
 	return b, nil
 }
 
+// NewAdapterWithSqlDB creates the adapter with the sqlDB opened by the caller.
+// The driver name may be empty, in which case it is detected from the driver of sqlDB.
 func NewAdapterWithSqlDB(sqlDB *sql.DB, driverName string, opts ...adapterOption) (*bunAdapter, error) {
 	db, err := openBunDB(sqlDB, driverName)
 	if err != nil {
@@ -86,231 +246,729 @@ func NewAdapterWithBunDB(db *bun.DB, opts ...adapterOption) (*bunAdapter, error)
 
 func newAdapter(db *bun.DB, opts ...adapterOption) (*bunAdapter, error) {
 	b := &bunAdapter{
-		db: db,
+		db:           db,
+		tableName:    defaultTableName,
+		fieldCount:   defaultFieldCount,
+		columnType:   defaultColumnType,
+		columnLength: defaultColumnLength,
 	}
 
 	for _, opt := range opts {
 		opt(b)
 	}
+	b.hashRules = !b.canCreateUniqueIndex()
 
 	if b.debugMode {
 		b.db.AddQueryHook(bundebug.NewQueryHook(bundebug.WithVerbose(true)))
 	}
 
-	if err := b.createTable(); err != nil {
-		return nil, err
+	if b.skipTable {
+		if err := b.inspectTable(context.Background()); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := b.prepareTable(context.Background()); err != nil {
+			return nil, err
+		}
 	}
 
-	runtime.SetFinalizer(b, func(a *bunAdapter) {
-		if err := a.db.Close(); err != nil {
-			panic(err)
-		}
-	})
+	// close the owned database in case Close is never called
+	if b.ownsDB {
+		runtime.SetFinalizer(b, func(a *bunAdapter) {
+			if err := a.db.Close(); err != nil {
+				log.Printf("casbinbunadapter: failed to close the database: %v", err)
+			}
+		})
+	}
 
 	return b, nil
 }
 
-func openSqlDB(driverName, dataSourceName string) (*sql.DB, error) {
-	switch driverName {
-	case "mysql":
-		return sql.Open(driverName, dataSourceName)
-	case "postgres":
-		return sql.OpenDB(pgdriver.NewConnector(pgdriver.WithDSN(dataSourceName))), nil
-	case "mssql":
-		return sql.Open(driverName, dataSourceName)
-	case "sqlite3":
-		return sql.Open(sqliteshim.ShimName, dataSourceName)
-	default:
-		return nil, fmt.Errorf("unsupported driver: %s", driverName)
+// Close closes the database if the adapter owns it, which is the case for NewAdapter and WithOwnership.
+// Otherwise the database is left open for the caller, and Close does nothing.
+func (a *bunAdapter) Close() error {
+	if !a.ownsDB {
+		return nil
 	}
+	runtime.SetFinalizer(a, nil)
+	return a.db.Close()
 }
 
-func openBunDB(sqlDB *sql.DB, driverName string) (*bun.DB, error) {
-	switch driverName {
-	case "mysql":
-		return bun.NewDB(sqlDB, mysqldialect.New()), nil
-	case "postgres":
-		return bun.NewDB(sqlDB, pgdialect.New()), nil
-	case "mssql":
-		return bun.NewDB(sqlDB, mssqldialect.New()), nil
-	case "sqlite3":
-		return bun.NewDB(sqlDB, sqlitedialect.New()), nil
-	default:
-		return nil, fmt.Errorf("unsupported driver: %s", driverName)
-	}
+// runInTx runs fn in a transaction, which is rolled back when fn fails.
+// With WithRetry, the whole transaction is run again on a retryable error,
+// so fn must not keep the state of the failed attempt.
+func (a *bunAdapter) runInTx(ctx context.Context, fn func(ctx context.Context, tx bun.Tx) error) error {
+	return a.retry(ctx, func(ctx context.Context) error {
+		return wrapTxError(a.db.RunInTx(ctx, &sql.TxOptions{}, fn))
+	})
 }
 
-func (a *bunAdapter) createTable() error {
-	if _, err := a.db.NewCreateTable().
-		Model((*CasbinPolicy)(nil)).
-		IfNotExists().
-		Exec(context.Background()); err != nil {
+// tableExpr returns the table name expression used for queries
+// which do not refer to the table alias.
+func (a *bunAdapter) tableExpr() (string, bun.Ident) {
+	return "?", bun.Ident(a.tableName)
+}
+
+// tableExprWithAlias returns the table name expression used for select queries,
+// in which bun qualifies the columns with the table alias.
+func (a *bunAdapter) tableExprWithAlias() (string, bun.Ident, bun.Ident) {
+	return "? AS ?", bun.Ident(a.tableName), bun.Ident(tableAlias)
+}
+
+// LoadPolicy loads all policy rules from the storage.
+func (a *bunAdapter) LoadPolicy(model model.Model) error {
+	return a.loadPolicy(context.Background(), model)
+}
+
+func (a *bunAdapter) loadPolicy(ctx context.Context, model model.Model) error {
+	if err := a.loadPolicyRecords(ctx, model, nil); err != nil {
 		return err
 	}
+
+	a.isFiltered.Store(false)
+
 	return nil
 }
 
-// LoadPolicy loads all policy rules from the storage.
-func (a *bunAdapter) LoadPolicy(model model.Model) error {
-	var policies []CasbinPolicy
-	err := a.db.NewSelect().
-		Model(&policies).
-		Scan(context.Background())
+// LoadFilteredPolicy loads only policy rules that match the filter.
+// The filter must be a Filter or a *Filter, and a nil filter loads all policy rules.
+func (a *bunAdapter) LoadFilteredPolicy(model model.Model, filter interface{}) error {
+	return a.loadFilteredPolicy(context.Background(), model, filter)
+}
+
+func (a *bunAdapter) loadFilteredPolicy(ctx context.Context, model model.Model, filter interface{}) error {
+	if filter == nil {
+		return a.loadPolicy(ctx, model)
+	}
+
+	f, err := toFilter(filter)
 	if err != nil {
 		return err
 	}
 
-	for _, policy := range policies {
-		if err := loadPolicyRecord(policy, model); err != nil {
+	if err := a.loadPolicyRecords(ctx, model, &f); err != nil {
+		return err
+	}
+
+	a.isFiltered.Store(true)
+
+	return nil
+}
+
+// loadPolicyRecords feeds the policies matching the filter into the model while scanning the rows,
+// so that the policies are never held in memory all at once. A nil filter loads all policies.
+// With WithPageSize, the policies are selected page by page in the order of their ids.
+func (a *bunAdapter) loadPolicyRecords(ctx context.Context, model model.Model, filter *Filter) error {
+	newQuery := func() *bun.SelectQuery {
+		query := a.db.NewSelect()
+		if filter != nil {
+			query = filter.apply(query)
+		}
+		return query
+	}
+	loader := newPolicyLoader(model)
+
+	if a.pageSize == 0 {
+		if err := a.scanPolicies(ctx, newQuery(), loader.add); err != nil {
 			return err
 		}
+		return loader.flush()
 	}
 
-	return nil
+	var lastID int64
+	for {
+		count := 0
+		query := newQuery().
+			Where("? > ?", bun.Ident("id"), lastID).
+			OrderExpr("? ASC", bun.Ident("id")).
+			Limit(a.pageSize)
+		if err := a.scanPolicies(ctx, query, func(policy CasbinPolicy) error {
+			count++
+			lastID = policy.ID
+			return loader.add(policy)
+		}); err != nil {
+			return err
+		}
+		if count < a.pageSize {
+			return loader.flush()
+		}
+	}
 }
 
-func loadPolicyRecord(policy CasbinPolicy, model model.Model) error {
-	pType := policy.PType
-	sec := pType[:1]
-	ok, err := model.HasPolicyEx(sec, pType, policy.filterValues())
+// selectPolicies runs the select query on the policy columns and returns the scanned policies.
+func (a *bunAdapter) selectPolicies(ctx context.Context, query *bun.SelectQuery) ([]CasbinPolicy, error) {
+	policies := make([]CasbinPolicy, 0)
+	err := a.scanPolicies(ctx, query, func(policy CasbinPolicy) error {
+		policies = append(policies, policy)
+		return nil
+	})
+	return policies, err
+}
+
+// scanPolicies runs the select query on the rule columns and calls fn with each scanned policy.
+// The rows are scanned manually since CasbinPolicy has no fields for the columns added by WithFieldCount.
+func (a *bunAdapter) scanPolicies(ctx context.Context, query *bun.SelectQuery, fn func(CasbinPolicy) error) error {
+	rows, err := query.
+		ModelTableExpr(a.tableExprWithAlias()).
+		Column("id").
+		Column(a.ruleColumns()...).
+		Rows(ctx)
 	if err != nil {
-		return err
+		return wrapError(err)
 	}
-	if ok {
-		return nil
+	return a.scanRows(rows, fn)
+}
+
+// scanRows scans the rows of the id and the policy columns, calling fn with each policy, and closes the rows.
+func (a *bunAdapter) scanRows(rows *sql.Rows, fn func(CasbinPolicy) error) error {
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id    int64
+			ptype string
+		)
+		var width sql.NullInt64
+		values := make([]sql.NullString, a.fieldCount)
+		dest := []interface{}{&id, &ptype}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		dest = append(dest, &width)
+		if err := rows.Scan(dest...); err != nil {
+			return wrapError(err)
+		}
+
+		rule := make([]string, 0, a.fieldCount)
+		for _, value := range values {
+			rule = append(rule, value.String)
+		}
+		policy := newCasbinPolicy(ptype, rule)
+		policy.ID = id
+		policy.Width = int(width.Int64)
+		if err := fn(policy); err != nil {
+			return err
+		}
+	}
+
+	return wrapError(rows.Err())
+}
+
+// IsFiltered returns true if the loaded policy has been filtered.
+func (a *bunAdapter) IsFiltered() bool {
+	return a.isFiltered.Load()
+}
+
+// newPolicy creates the policy of the rule,
+// failing when the rule has more values than the value columns of the table.
+func (a *bunAdapter) newPolicy(ptype string, rule []string) (CasbinPolicy, error) {
+	if len(rule) > a.fieldCount {
+		return CasbinPolicy{}, fmt.Errorf("%w: %d values exceed the %d value columns, use WithFieldCount to store longer rules", ErrInvalidRule, len(rule), a.fieldCount)
+	}
+	return newCasbinPolicy(ptype, rule), nil
+}
+
+// validateLengths checks that the values of the policies fit in their columns,
+// so that they are neither truncated nor rejected with a dialect-specific error by the database.
+// The length is counted in characters, and the size of the index entry on postgres in bytes.
+func (a *bunAdapter) validateLengths(policies ...CasbinPolicy) error {
+	for _, policy := range policies {
+		values := append([]string{policy.PType}, policy.values(a.fieldCount)...)
+		for i, column := range a.policyColumns() {
+			maxLength := a.columnLength
+			if column == "ptype" {
+				maxLength = ptypeColumnLength
+			}
+			value := values[i]
+			if length := utf8.RuneCountInString(value); maxLength > 0 && length > maxLength {
+				return &ValueTooLongError{Column: column, Value: value, Length: length, MaxLength: maxLength}
+			}
+		}
+		// postgres rejects the index entries beyond its limit, which the column types do not prevent
+		if !a.hashRules && a.db.Dialect().Name() == dialect.PG {
+			if size := pgIndexEntrySize(values); size > pgMaxKeyLength {
+				return fmt.Errorf("%w: the rule %v takes %d bytes in the unique index, which exceeds %d bytes", ErrInvalidRule, policy.toSlice(), size, pgMaxKeyLength)
+			}
+		}
 	}
-	model.AddPolicy(sec, pType, policy.filterValues())
 	return nil
 }
 
+// newPolicies creates the policies of the rules with newPolicy.
+func (a *bunAdapter) newPolicies(ptype string, rules [][]string) ([]CasbinPolicy, error) {
+	policies := make([]CasbinPolicy, 0, len(rules))
+	for _, rule := range rules {
+		policy, err := a.newPolicy(ptype, rule)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
 // SavePolicy saves all policy rules to the storage.
+// It refuses to save a filtered policy, since the model only holds a part of the stored rules.
 func (a *bunAdapter) SavePolicy(model model.Model) error {
+	return a.savePolicy(context.Background(), model)
+}
+
+func (a *bunAdapter) savePolicy(ctx context.Context, model model.Model) error {
+	_, err := a.savePolicyWithResult(ctx, model)
+	return err
+}
+
+// SaveResult is the number of the rows changed by SavePolicyWithResult.
+type SaveResult struct {
+	Added     int
+	Removed   int
+	Unchanged int
+}
+
+// SavePolicyWithResult saves all policy rules to the storage like SavePolicy,
+// and returns the number of the rows added, removed and left unchanged.
+// Without WithIncrementalSave, all the stored rows are removed and no row is left unchanged.
+func (a *bunAdapter) SavePolicyWithResult(model model.Model) (SaveResult, error) {
+	return a.savePolicyWithResult(context.Background(), model)
+}
+
+func (a *bunAdapter) savePolicyWithResult(ctx context.Context, model model.Model) (SaveResult, error) {
+	if a.isFiltered.Load() {
+		return SaveResult{}, ErrFilteredPolicy
+	}
+
 	policies := make([]CasbinPolicy, 0)
 
 	// go through policy definitions
 	for ptype, ast := range model["p"] {
-		for _, rule := range ast.Policy {
-			policies = append(policies, newCasbinPolicy(ptype, rule))
+		newPolicies, err := a.newPolicies(ptype, ast.Policy)
+		if err != nil {
+			return SaveResult{}, err
 		}
+		policies = append(policies, newPolicies...)
 	}
 
 	// go through role definitions
 	for ptype, ast := range model["g"] {
-		for _, rule := range ast.Policy {
-			policies = append(policies, newCasbinPolicy(ptype, rule))
+		newPolicies, err := a.newPolicies(ptype, ast.Policy)
+		if err != nil {
+			return SaveResult{}, err
 		}
+		policies = append(policies, newPolicies...)
+	}
+
+	if a.incrementalSave {
+		return a.savePolicyDiff(ctx, policies)
 	}
+	return a.savePolicyRecords(ctx, policies)
+}
+
+// savePolicyRecords replaces the stored policies with the policies in a transaction,
+// so that the table is never seen empty and keeps the old policies when the insert fails.
+func (a *bunAdapter) savePolicyRecords(ctx context.Context, policies []CasbinPolicy) (SaveResult, error) {
+	var result SaveResult
+	change := policyChange{method: UpdateForSavePolicy}
+	err := a.writeInTx(ctx, &change, func(ctx context.Context, tx bun.Tx) error {
+		// the rules actually added and removed are recorded, rather than all the rules replaced
+		if a.recordsPolicies() {
+			stored, err := a.selectPolicies(ctx, tx.NewSelect())
+			if err != nil {
+				return err
+			}
+			change.added, change.removed = a.diffPolicies(stored, policies)
+		}
+
+		// delete existing policies
+		removed, err := a.refreshTable(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		// bulk insert new policies
+		if err := a.insertPolicies(ctx, tx, policies); err != nil {
+			return err
+		}
 
-	return a.savePolicyRecords(policies)
+		result = SaveResult{Added: len(policies), Removed: int(removed)}
+		return nil
+	})
+	return result, err
 }
 
-func (a *bunAdapter) savePolicyRecords(policies []CasbinPolicy) error {
-	// delete existing policies
-	if err := a.refreshTable(); err != nil {
+// savePolicyDiff inserts the policies which are not stored yet and deletes the stored rows which are not in the policies,
+// in a transaction. The stored duplicates of a policy are deleted as well.
+func (a *bunAdapter) savePolicyDiff(ctx context.Context, policies []CasbinPolicy) (SaveResult, error) {
+	var result SaveResult
+	change := policyChange{method: UpdateForSavePolicy}
+	err := a.writeInTx(ctx, &change, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		result, err = a.applyDiff(ctx, tx, &change, policies)
 		return err
+	})
+	return result, err
+}
+
+// applyDiff replaces the stored policies with the policies in the transaction, only writing the difference,
+// and sets the policies added and removed to the change.
+func (a *bunAdapter) applyDiff(ctx context.Context, tx bun.Tx, change *policyChange, policies []CasbinPolicy) (SaveResult, error) {
+	stored, err := a.selectPolicies(ctx, tx.NewSelect())
+	if err != nil {
+		return SaveResult{}, err
+	}
+	added, removed := a.diffPolicies(stored, policies)
+
+	removedIDs := make([]int64, 0, len(removed))
+	for _, policy := range removed {
+		removedIDs = append(removedIDs, policy.ID)
+	}
+	for _, ids := range chunk(removedIDs, a.batchSizeOf(tx)) {
+		if _, err := tx.NewDelete().
+			Model((*CasbinPolicy)(nil)).
+			ModelTableExpr(a.tableExpr()).
+			Where("id IN (?)", bun.In(ids)).
+			Exec(ctx); err != nil {
+			return SaveResult{}, err
+		}
+	}
+	if err := a.insertPolicies(ctx, tx, added); err != nil {
+		return SaveResult{}, err
+	}
+
+	change.added, change.removed = added, removed
+	return SaveResult{
+		Added:     len(added),
+		Removed:   len(removed),
+		Unchanged: len(stored) - len(removed),
+	}, nil
+}
+
+// diffPolicies returns the policies which are not stored yet, and the stored rows which are not in the policies.
+// The stored duplicates of a policy are removed as well, and the duplicates in the policies are added only once.
+func (a *bunAdapter) diffPolicies(stored, policies []CasbinPolicy) (added, removed []CasbinPolicy) {
+	wanted := make(map[string]bool, len(policies))
+	for _, policy := range policies {
+		wanted[a.policyKey(policy)] = true
+	}
+
+	kept := make(map[string]bool, len(stored))
+	for _, policy := range stored {
+		key := a.policyKey(policy)
+		if !wanted[key] || kept[key] {
+			removed = append(removed, policy)
+			continue
+		}
+		kept[key] = true
 	}
 
-	// bulk insert new policies
-	if _, err := a.db.NewInsert().
-		Model(&policies).
-		Exec(context.Background()); err != nil {
+	for _, policy := range policies {
+		key := a.policyKey(policy)
+		if kept[key] {
+			continue
+		}
+		// mark it as kept, so that the duplicates in the policies are added only once
+		kept[key] = true
+		added = append(added, policy)
+	}
+	return added, removed
+}
+
+// policyKey returns the key which identifies the stored row of the policy.
+func (a *bunAdapter) policyKey(policy CasbinPolicy) string {
+	return strings.Join(append([]string{policy.PType}, policy.values(a.fieldCount)...), "\x00")
+}
+
+// ruleHash returns the hex encoded SHA-256 of the policy key, which is stored in ruleHashColumn.
+func (a *bunAdapter) ruleHash(policy CasbinPolicy) string {
+	sum := sha256.Sum256([]byte(a.policyKey(policy)))
+	return hex.EncodeToString(sum[:])
+}
+
+// insertPolicies bulk inserts the policies with db, which is either the bun.DB or a bun.Tx.
+// The policies are split into the batches of batchSizeOf, so the caller runs it in a transaction
+// when all of them must be inserted or none.
+func (a *bunAdapter) insertPolicies(ctx context.Context, db bun.IDB, policies []CasbinPolicy) error {
+	if len(policies) == 0 {
+		return nil
+	}
+	if err := a.validateLengths(policies...); err != nil {
 		return err
 	}
 
+	if a.ignoreDuplicates && !supportsInsertIgnore(db) {
+		return a.insertPoliciesOneByOne(ctx, db, policies)
+	}
+
+	for _, batch := range chunk(policies, a.batchSizeOf(db)) {
+		rows := make([][]interface{}, 0, len(batch))
+		for _, policy := range batch {
+			rows = append(rows, a.insertRow(policy))
+		}
+		if _, err := a.insertQuery(db, rows).Exec(ctx); err != nil {
+			return wrapError(err)
+		}
+	}
 	return nil
 }
 
-// truncate tables
-func (a *bunAdapter) refreshTable() error {
-	if _, err := a.db.NewTruncateTable().
-		Model((*CasbinPolicy)(nil)).
-		Exec(context.Background()); err != nil {
-		return err
+// insertPoliciesOneByOne inserts the policies one by one skipping the duplicates,
+// which is used for the dialects that support neither INSERT IGNORE nor ON CONFLICT DO NOTHING (mssql).
+func (a *bunAdapter) insertPoliciesOneByOne(ctx context.Context, db bun.IDB, policies []CasbinPolicy) error {
+	for _, policy := range policies {
+		rows := [][]interface{}{a.insertRow(policy)}
+		if _, err := a.insertQuery(db, rows).Exec(ctx); err != nil && !isUniqueViolation(err) {
+			return err
+		}
 	}
 	return nil
 }
 
+// insertQuery builds the query to bulk insert the rows.
+// bun supports neither bulk inserts of maps nor columns missing in the model,
+// so the query is built from the policy columns, which include the ones added by WithFieldCount.
+func (a *bunAdapter) insertQuery(db bun.IDB, rows [][]interface{}) *bun.RawQuery {
+	query := "INSERT INTO ? (?) VALUES ?"
+	if a.ignoreDuplicates {
+		switch {
+		case db.Dialect().Features().Has(feature.InsertIgnore):
+			query = "INSERT IGNORE INTO ? (?) VALUES ?"
+		case db.Dialect().Features().Has(feature.InsertOnConflict):
+			query = "INSERT INTO ? (?) VALUES ? ON CONFLICT DO NOTHING"
+		}
+	}
+	columns := a.ruleColumnIdents()
+	if a.hashRules {
+		columns = append(columns, bun.Ident(ruleHashColumn))
+	}
+	return db.NewRaw(query, bun.Ident(a.tableName), bun.In(columns), bun.In(rows))
+}
+
+// insertRow returns the values of the policy in the order of the columns of insertQuery,
+// which are the rule columns followed by the hash of the rule when the adapter hashes them.
+func (a *bunAdapter) insertRow(policy CasbinPolicy) []interface{} {
+	row := a.ruleRow(policy)
+	if a.hashRules {
+		row = append(row, a.ruleHash(policy))
+	}
+	return row
+}
+
+// policyRow returns the values of the policy in the order of policyColumns.
+func (a *bunAdapter) policyRow(policy CasbinPolicy) []interface{} {
+	row := []interface{}{policy.PType}
+	for _, value := range policy.values(a.fieldCount) {
+		row = append(row, value)
+	}
+	return row
+}
+
+// ruleRow returns the values of the policy followed by its width, in the order of ruleColumns.
+func (a *bunAdapter) ruleRow(policy CasbinPolicy) []interface{} {
+	return append(a.policyRow(policy), ruleWidth(policy))
+}
+
+// ruleWidth returns the width of the policy to store, which is NULL when it is unknown.
+func ruleWidth(policy CasbinPolicy) interface{} {
+	if policy.Width == 0 {
+		return nil
+	}
+	return policy.Width
+}
+
+// batchSizeOf returns the maximum number of the rows written by a single statement with db.
+// bun inlines the values into the query, so the limits of the bind parameters do not apply,
+// but SQL Server accepts at most 1000 rows in VALUES and older SQLite at most 500,
+// and MySQL rejects the queries longer than max_allowed_packet.
+func (a *bunAdapter) batchSizeOf(db bun.IDB) int {
+	if a.batchSize > 0 {
+		return a.batchSize
+	}
+	switch db.Dialect().Name() {
+	case dialect.MSSQL:
+		return mssqlMaxInsertRows
+	case dialect.SQLite:
+		return sqliteMaxInsertRows
+	default:
+		return defaultBatchSize
+	}
+}
+
+// chunk splits the values into the chunks of at most size values.
+func chunk[T any](values []T, size int) [][]T {
+	chunks := make([][]T, 0, (len(values)+size-1)/size)
+	for size < len(values) {
+		chunks = append(chunks, values[:size])
+		values = values[size:]
+	}
+	if len(values) > 0 {
+		chunks = append(chunks, values)
+	}
+	return chunks
+}
+
+func supportsInsertIgnore(db bun.IDB) bool {
+	return db.Dialect().Features().Has(feature.InsertIgnore) || db.Dialect().Features().Has(feature.InsertOnConflict)
+}
+
+// refreshTable deletes all the policies with db, which is either the bun.DB or a bun.Tx,
+// and returns the number of the deleted rows.
+// DELETE is used instead of TRUNCATE, since TRUNCATE commits the transaction implicitly on MySQL.
+func (a *bunAdapter) refreshTable(ctx context.Context, db bun.IDB) (int64, error) {
+	query := db.NewDelete().
+		Model((*CasbinPolicy)(nil)).
+		ModelTableExpr(a.tableExpr()).
+		Where("1 = 1")
+	return a.delete(ctx, query)
+}
+
 // AddPolicy adds a policy rule to the storage.
 // This is part of the Auto-Save feature.
 func (a *bunAdapter) AddPolicy(sec string, ptype string, rule []string) error {
-	newPolicy := newCasbinPolicy(ptype, rule)
-	if _, err := a.db.NewInsert().
-		Model(&newPolicy).
-		Exec(context.Background()); err != nil {
+	return a.addPolicy(context.Background(), sec, ptype, rule)
+}
+
+func (a *bunAdapter) addPolicy(ctx context.Context, sec string, ptype string, rule []string) error {
+	newPolicy, err := a.newPolicy(ptype, rule)
+	if err != nil {
 		return err
 	}
-	return nil
+	change := policyChange{method: UpdateForAddPolicy, sec: sec, ptype: ptype, rules: [][]string{rule}}
+	return a.write(ctx, &change, func(ctx context.Context, db bun.IDB) error {
+		return a.insertPolicies(ctx, db, []CasbinPolicy{newPolicy})
+	})
 }
 
 // AddPolicies adds policy rules to the storage.
 // This is part of the Auto-Save feature.
 func (a *bunAdapter) AddPolicies(sec string, ptype string, rules [][]string) error {
-	policies := make([]CasbinPolicy, 0)
-	for _, rule := range rules {
-		policies = append(policies, newCasbinPolicy(ptype, rule))
-	}
-	if _, err := a.db.NewInsert().
-		Model(&policies).
-		Exec(context.Background()); err != nil {
+	return a.addPolicies(context.Background(), sec, ptype, rules)
+}
+
+func (a *bunAdapter) addPolicies(ctx context.Context, sec string, ptype string, rules [][]string) error {
+	policies, err := a.newPolicies(ptype, rules)
+	if err != nil {
 		return err
 	}
-	return nil
+	change := policyChange{method: UpdateForAddPolicies, sec: sec, ptype: ptype, rules: rules}
+	return a.writeInTx(ctx, &change, func(ctx context.Context, tx bun.Tx) error {
+		return a.insertPolicies(ctx, tx, policies)
+	})
 }
 
 // RemovePolicy removes a policy rule from the storage.
 // This is part of the Auto-Save feature.
 func (a *bunAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
-	exisingPolicy := newCasbinPolicy(ptype, rule)
-	if err := a.deleteRecord(exisingPolicy); err != nil {
+	return a.removePolicy(context.Background(), sec, ptype, rule)
+}
+
+func (a *bunAdapter) removePolicy(ctx context.Context, sec string, ptype string, rule []string) error {
+	_, err := a.removePolicyWithResult(ctx, sec, ptype, rule)
+	return err
+}
+
+// RemovePolicyWithResult removes a policy rule from the storage like RemovePolicy,
+// and returns the number of the rows removed, which is zero when the rule does not exist.
+func (a *bunAdapter) RemovePolicyWithResult(sec string, ptype string, rule []string) (int, error) {
+	return a.removePolicyWithResult(context.Background(), sec, ptype, rule)
+}
+
+func (a *bunAdapter) removePolicyWithResult(ctx context.Context, sec string, ptype string, rule []string) (int, error) {
+	exisingPolicy, err := a.newPolicy(ptype, rule)
+	if err != nil {
+		return 0, err
+	}
+	var removed int64
+	change := policyChange{method: UpdateForRemovePolicy, sec: sec, ptype: ptype, rules: [][]string{rule}}
+	err = a.write(ctx, &change, func(ctx context.Context, db bun.IDB) error {
+		removed, err = a.deleteRecord(ctx, db, exisingPolicy)
 		return err
+	})
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	return int(removed), nil
 }
 
 // RemovePolicies removes policy rules from the storage.
 // This is part of the Auto-Save feature.
 func (a *bunAdapter) RemovePolicies(sec string, ptype string, rules [][]string) error {
-	return a.db.RunInTx(context.Background(), &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
-		for _, rule := range rules {
-			exisingPolicy := newCasbinPolicy(ptype, rule)
-			if err := a.deleteRecordInTx(tx, exisingPolicy); err != nil {
+	return a.removePolicies(context.Background(), sec, ptype, rules)
+}
+
+func (a *bunAdapter) removePolicies(ctx context.Context, sec string, ptype string, rules [][]string) error {
+	_, err := a.removePoliciesWithResult(ctx, sec, ptype, rules)
+	return err
+}
+
+// RemovePoliciesWithResult removes policy rules from the storage like RemovePolicies,
+// and returns the number of the rows removed, which does not count the rules that do not exist.
+func (a *bunAdapter) RemovePoliciesWithResult(sec string, ptype string, rules [][]string) (int, error) {
+	return a.removePoliciesWithResult(context.Background(), sec, ptype, rules)
+}
+
+func (a *bunAdapter) removePoliciesWithResult(ctx context.Context, sec string, ptype string, rules [][]string) (int, error) {
+	existingPolicies, err := a.newPolicies(ptype, rules)
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int64
+	change := policyChange{method: UpdateForRemovePolicies, sec: sec, ptype: ptype, rules: rules}
+	err = a.writeInTx(ctx, &change, func(ctx context.Context, tx bun.Tx) error {
+		// the transaction may be retried, so the count starts over
+		removed = 0
+		for _, exisingPolicy := range existingPolicies {
+			n, err := a.deleteRecord(ctx, tx, exisingPolicy)
+			if err != nil {
 				return err
 			}
+			removed += n
 		}
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+	return int(removed), nil
 }
 
-func (a *bunAdapter) deleteRecord(existingPolicy CasbinPolicy) error {
-	query := a.db.NewDelete().
-		Model((*CasbinPolicy)(nil)).
-		Where("ptype = ?", existingPolicy.PType)
-
-	values := existingPolicy.filterValuesWithKey()
-
-	return a.delete(query, values)
-}
-
-func (a *bunAdapter) deleteRecordInTx(tx bun.Tx, existingPolicy CasbinPolicy) error {
-	query := tx.NewDelete().
+// deleteRecord deletes the policy with db, which is either the bun.DB or a bun.Tx, and returns the number of the deleted rows.
+// With WithStrictMatch, it fails with ErrPolicyNotFound when no row matches the policy.
+func (a *bunAdapter) deleteRecord(ctx context.Context, db bun.IDB, existingPolicy CasbinPolicy) (int64, error) {
+	query := db.NewDelete().
 		Model((*CasbinPolicy)(nil)).
-		Where("ptype = ?", existingPolicy.PType)
+		ModelTableExpr(a.tableExpr()).
+		ApplyQueryBuilder(a.policyFilter(existingPolicy))
 
-	values := existingPolicy.filterValuesWithKey()
+	affected, err := a.delete(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 && a.strictMatch {
+		return 0, fmt.Errorf("%w: %v", ErrPolicyNotFound, existingPolicy.toSlice())
+	}
 
-	return a.delete(query, values)
+	return affected, nil
 }
 
-func (a *bunAdapter) delete(query *bun.DeleteQuery, values map[string]string) error {
-	for key, value := range values {
-		query = query.Where(fmt.Sprintf("%s = ?", key), value)
+// delete runs the delete query and returns the number of the deleted rows.
+func (a *bunAdapter) delete(ctx context.Context, query *bun.DeleteQuery) (int64, error) {
+	res, err := query.Exec(ctx)
+	if err != nil {
+		return 0, wrapError(err)
 	}
+	return res.RowsAffected()
+}
 
-	if _, err := query.Exec(context.Background()); err != nil {
-		return err
+// policyFilter returns the query builder function which matches the stored row of the policy exactly,
+// comparing every value column including the empty ones.
+func (a *bunAdapter) policyFilter(policy CasbinPolicy) func(bun.QueryBuilder) bun.QueryBuilder {
+	return func(q bun.QueryBuilder) bun.QueryBuilder {
+		row := a.policyRow(policy)
+		for i, column := range a.policyColumns() {
+			q = q.Where("? = ?", bun.Ident(column), row[i])
+		}
+		return q
 	}
-
-	return nil
 }
 
 // RemoveFilteredPolicy removes policy rules that match the filter from the storage.
@@ -318,247 +976,256 @@ func (a *bunAdapter) delete(query *bun.DeleteQuery, values map[string]string) er
 // This API is explained in the link below:
 // https://casbin.org/docs/management-api/#removefilteredpolicy
 func (a *bunAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
-	if err := a.deleteFilteredPolicy(ptype, fieldIndex, fieldValues...); err != nil {
+	return a.removeFilteredPolicy(context.Background(), sec, ptype, fieldIndex, fieldValues...)
+}
+
+func (a *bunAdapter) removeFilteredPolicy(ctx context.Context, sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	change := policyChange{method: UpdateForRemoveFilteredPolicy, sec: sec, ptype: ptype, fieldIndex: fieldIndex, fieldValues: fieldValues}
+	return a.write(ctx, &change, func(ctx context.Context, db bun.IDB) error {
+		if a.recordsPolicies() {
+			filter, err := a.fieldFilter(ptype, fieldIndex, fieldValues...)
+			if err != nil {
+				return err
+			}
+			if change.removed, err = a.selectPolicies(ctx, db.NewSelect().ApplyQueryBuilder(filter)); err != nil {
+				return err
+			}
+		}
+		_, err := a.deleteFilteredPolicy(ctx, db, ptype, fieldIndex, fieldValues...)
 		return err
-	}
-	return nil
+	})
 }
 
-func (a *bunAdapter) deleteFilteredPolicy(ptype string, fieldIndex int, fieldValues ...string) error {
-	query := a.db.NewDelete().
+// deleteFilteredPolicy deletes the policies matching the filter and returns the number of the deleted rows.
+// Unlike deleteRecord, deleting no rows is not an error, since a filter may match no policies.
+func (a *bunAdapter) deleteFilteredPolicy(ctx context.Context, db bun.IDB, ptype string, fieldIndex int, fieldValues ...string) (int64, error) {
+	filter, err := a.fieldFilter(ptype, fieldIndex, fieldValues...)
+	if err != nil {
+		return 0, err
+	}
+
+	query := db.NewDelete().
 		Model((*CasbinPolicy)(nil)).
-		Where("ptype = ?", ptype)
+		ModelTableExpr(a.tableExpr()).
+		ApplyQueryBuilder(filter)
 
-	// Note that empty string in fieldValues could be any word.
-	if fieldIndex <= 0 && 0 < fieldIndex+len(fieldValues) {
-		value := fieldValues[0-fieldIndex]
-		if value == "" {
-			query = query.Where("v0 LIKE '%'")
-		} else {
-			query = query.Where("v0 = ?", value)
-		}
-	}
-	if fieldIndex <= 1 && 1 < fieldIndex+len(fieldValues) {
-		value := fieldValues[1-fieldIndex]
-		if value == "" {
-			query = query.Where("v1 LIKE '%'")
-		} else {
-			query = query.Where("v1 = ?", value)
-		}
-	}
-	if fieldIndex <= 2 && 2 < fieldIndex+len(fieldValues) {
-		value := fieldValues[2-fieldIndex]
-		if value == "" {
-			query = query.Where("v2 LIKE '%'")
-		} else {
-			query = query.Where("v2 = ?", value)
-		}
-	}
-	if fieldIndex <= 3 && 3 < fieldIndex+len(fieldValues) {
-		value := fieldValues[3-fieldIndex]
-		if value == "" {
-			query = query.Where("v3 LIKE '%'")
-		} else {
-			query = query.Where("v3 = ?", value)
-		}
-	}
-	if fieldIndex <= 4 && 4 < fieldIndex+len(fieldValues) {
-		value := fieldValues[4-fieldIndex]
-		if value == "" {
-			query = query.Where("v4 LIKE '%'")
-		} else {
-			query = query.Where("v4 = ?", value)
-		}
-	}
-	if fieldIndex <= 5 && 5 < fieldIndex+len(fieldValues) {
-		value := fieldValues[5-fieldIndex]
-		if value == "" {
-			query = query.Where("v5 LIKE '%'")
-		} else {
-			query = query.Where("v5 = ?", value)
-		}
-	}
+	return a.delete(ctx, query)
+}
 
-	if _, err := query.Exec(context.Background()); err != nil {
-		return err
+// fieldFilter returns the query builder function which adds the conditions of fieldIndex and fieldValues to the query.
+// It fails when the field values go beyond the value columns, since such a filter would match too many rules.
+func (a *bunAdapter) fieldFilter(ptype string, fieldIndex int, fieldValues ...string) (func(bun.QueryBuilder) bun.QueryBuilder, error) {
+	if fieldIndex < 0 || fieldIndex+len(fieldValues) > a.fieldCount {
+		return nil, fmt.Errorf("%w: field index %d with %d values exceeds the %d value columns", ErrInvalidRule, fieldIndex, len(fieldValues), a.fieldCount)
 	}
 
-	return nil
+	return func(q bun.QueryBuilder) bun.QueryBuilder {
+		q = q.Where("ptype = ?", ptype)
+		for i, value := range fieldValues {
+			column := bun.Ident(valueColumn(fieldIndex + i))
+			// Note that empty string in fieldValues could be any word.
+			if value == "" {
+				q = q.Where("? LIKE '%'", column)
+			} else {
+				q = q.Where("? = ?", column, value)
+			}
+		}
+		return q
+	}, nil
 }
 
 // UpdatePolicy updates a policy rule from storage.
 // This is part of the Auto-Save feature.
 func (a *bunAdapter) UpdatePolicy(sec string, ptype string, oldRule, newRule []string) error {
-	oldPolicy := newCasbinPolicy(ptype, oldRule)
-	newPolicy := newCasbinPolicy(ptype, newRule)
-	return a.updateRecord(oldPolicy, newPolicy)
+	return a.updatePolicy(context.Background(), sec, ptype, oldRule, newRule)
 }
 
-func (a *bunAdapter) updateRecord(oldPolicy, newPolicy CasbinPolicy) error {
-	query := a.db.NewUpdate().
-		Model(&newPolicy).
-		Where("ptype = ?", oldPolicy.PType)
-
-	values := oldPolicy.filterValuesWithKey()
+func (a *bunAdapter) updatePolicy(ctx context.Context, sec string, ptype string, oldRule, newRule []string) error {
+	_, err := a.updatePolicyWithResult(ctx, sec, ptype, oldRule, newRule)
+	return err
+}
 
-	return a.update(query, values)
+// UpdatePolicyWithResult updates a policy rule from storage like UpdatePolicy,
+// and returns the number of the rows updated, which is zero when the old rule does not exist.
+func (a *bunAdapter) UpdatePolicyWithResult(sec string, ptype string, oldRule, newRule []string) (int, error) {
+	return a.updatePolicyWithResult(context.Background(), sec, ptype, oldRule, newRule)
 }
 
-func (a *bunAdapter) updateRecordInTx(tx bun.Tx, oldPolicy, newPolicy CasbinPolicy) error {
-	query := tx.NewUpdate().
-		Model(&newPolicy).
-		Where("ptype = ?", oldPolicy.PType)
+func (a *bunAdapter) updatePolicyWithResult(ctx context.Context, sec string, ptype string, oldRule, newRule []string) (int, error) {
+	oldPolicy, err := a.newPolicy(ptype, oldRule)
+	if err != nil {
+		return 0, err
+	}
+	newPolicy, err := a.newPolicy(ptype, newRule)
+	if err != nil {
+		return 0, err
+	}
+	if err := a.validateLengths(newPolicy); err != nil {
+		return 0, err
+	}
+	var updated int64
+	change := policyChange{method: UpdateForUpdatePolicy, sec: sec, ptype: ptype, rules: [][]string{oldRule}, newRules: [][]string{newRule}}
+	err = a.write(ctx, &change, func(ctx context.Context, db bun.IDB) error {
+		updated, err = a.updateRecord(ctx, db, oldPolicy, newPolicy)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(updated), nil
+}
 
-	values := oldPolicy.filterValuesWithKey()
+// updateRecord updates the policy with db, which is either the bun.DB or a bun.Tx, and returns the number of the updated rows.
+// With WithStrictMatch, it fails with ErrPolicyNotFound when no row matches the old policy.
+func (a *bunAdapter) updateRecord(ctx context.Context, db bun.IDB, oldPolicy, newPolicy CasbinPolicy) (int64, error) {
+	row := a.policyRow(newPolicy)
+
+	var (
+		affected int64
+		err      error
+	)
+	if slices.Equal(a.policyRow(oldPolicy), row) {
+		// MySQL reports no affected rows for an update which changes nothing, so the row is counted instead
+		affected, err = a.countRecord(ctx, db, oldPolicy)
+	} else {
+		query := db.NewUpdate().
+			ModelTableExpr(a.tableExpr()).
+			ApplyQueryBuilder(a.policyFilter(oldPolicy))
+		for i, column := range a.policyColumns() {
+			query = query.Set("? = ?", bun.Ident(column), row[i])
+		}
+		query = query.Set("? = ?", bun.Ident(ruleWidthColumn), ruleWidth(newPolicy))
+		if a.hashRules {
+			query = query.Set("? = ?", bun.Ident(ruleHashColumn), a.ruleHash(newPolicy))
+		}
+		affected, err = a.update(ctx, query)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 && a.strictMatch {
+		return 0, fmt.Errorf("%w: %v", ErrPolicyNotFound, oldPolicy.toSlice())
+	}
 
-	return a.update(query, values)
+	return affected, nil
 }
 
-func (a *bunAdapter) update(query *bun.UpdateQuery, values map[string]string) error {
-	for key, value := range values {
-		query = query.Where(fmt.Sprintf("%s = ?", key), value)
-	}
+// countRecord returns the number of the rows matching the policy.
+func (a *bunAdapter) countRecord(ctx context.Context, db bun.IDB, policy CasbinPolicy) (int64, error) {
+	n, err := db.NewSelect().
+		TableExpr(a.tableExpr()).
+		ApplyQueryBuilder(a.policyFilter(policy)).
+		Count(ctx)
+	return int64(n), wrapError(err)
+}
 
-	if _, err := query.Exec(context.Background()); err != nil {
-		return err
+// update runs the update query and returns the number of the updated rows.
+func (a *bunAdapter) update(ctx context.Context, query *bun.UpdateQuery) (int64, error) {
+	res, err := query.Exec(ctx)
+	if err != nil {
+		return 0, wrapError(err)
 	}
-
-	return nil
+	return res.RowsAffected()
 }
 
 // UpdatePolicies updates some policy rules to storage, like db, redis.
 func (a *bunAdapter) UpdatePolicies(sec string, ptype string, oldRules, newRules [][]string) error {
-	oldPolicies := make([]CasbinPolicy, 0, len(oldRules))
-	newPolicies := make([]CasbinPolicy, 0, len(newRules))
-	for _, rule := range oldRules {
-		oldPolicies = append(oldPolicies, newCasbinPolicy(ptype, rule))
+	return a.updatePolicies(context.Background(), sec, ptype, oldRules, newRules)
+}
+
+func (a *bunAdapter) updatePolicies(ctx context.Context, sec string, ptype string, oldRules, newRules [][]string) error {
+	_, err := a.updatePoliciesWithResult(ctx, sec, ptype, oldRules, newRules)
+	return err
+}
+
+// UpdatePoliciesWithResult updates some policy rules to storage like UpdatePolicies,
+// and returns the number of the rows updated, which does not count the old rules that do not exist.
+func (a *bunAdapter) UpdatePoliciesWithResult(sec string, ptype string, oldRules, newRules [][]string) (int, error) {
+	return a.updatePoliciesWithResult(context.Background(), sec, ptype, oldRules, newRules)
+}
+
+func (a *bunAdapter) updatePoliciesWithResult(ctx context.Context, sec string, ptype string, oldRules, newRules [][]string) (int, error) {
+	if len(oldRules) != len(newRules) {
+		return 0, fmt.Errorf("%w: %d old rules with %d new rules", ErrInvalidRule, len(oldRules), len(newRules))
 	}
-	for _, rule := range newRules {
-		newPolicies = append(newPolicies, newCasbinPolicy(ptype, rule))
+	oldPolicies, err := a.newPolicies(ptype, oldRules)
+	if err != nil {
+		return 0, err
+	}
+	newPolicies, err := a.newPolicies(ptype, newRules)
+	if err != nil {
+		return 0, err
+	}
+	if err := a.validateLengths(newPolicies...); err != nil {
+		return 0, err
 	}
 
-	return a.db.RunInTx(context.Background(), &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+	var updated int64
+	change := policyChange{method: UpdateForUpdatePolicies, sec: sec, ptype: ptype, rules: oldRules, newRules: newRules}
+	err = a.writeInTx(ctx, &change, func(ctx context.Context, tx bun.Tx) error {
+		// the transaction may be retried, so the count starts over
+		updated = 0
 		for i := range oldPolicies {
-			if err := a.updateRecordInTx(tx, oldPolicies[i], newPolicies[i]); err != nil {
+			n, err := a.updateRecord(ctx, tx, oldPolicies[i], newPolicies[i])
+			if err != nil {
 				return err
 			}
+			updated += n
 		}
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+	return int(updated), nil
 }
 
 // UpdateFilteredPolicies deletes old rules and adds new rules.
 func (a *bunAdapter) UpdateFilteredPolicies(sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
-	newPolicies := make([]CasbinPolicy, 0, len(newRules))
-	for _, rule := range newRules {
-		newPolicies = append(newPolicies, newCasbinPolicy(ptype, rule))
-	}
+	return a.updateFilteredPolicies(context.Background(), sec, ptype, newRules, fieldIndex, fieldValues...)
+}
 
-	tx, err := a.db.BeginTx(context.Background(), &sql.TxOptions{})
+func (a *bunAdapter) updateFilteredPolicies(ctx context.Context, sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+	newPolicies, err := a.newPolicies(ptype, newRules)
 	if err != nil {
 		return nil, err
 	}
-
-	oldPolicies := make([]CasbinPolicy, 0)
-	selectQuery := tx.NewSelect().
-		Model(&oldPolicies).
-		Where("ptype = ?", ptype)
-	deleteQuery := tx.NewDelete().
-		Model((*CasbinPolicy)(nil)).
-		Where("ptype = ?", ptype)
-
-	// Note that empty string in fieldValues could be any word.
-	if fieldIndex <= 0 && 0 < fieldIndex+len(fieldValues) {
-		value := fieldValues[0-fieldIndex]
-		if value == "" {
-			selectQuery = selectQuery.Where("v0 LIKE '%'")
-			deleteQuery = deleteQuery.Where("v0 LIKE '%'")
-		} else {
-			selectQuery = selectQuery.Where("v0 = ?", value)
-			deleteQuery = deleteQuery.Where("v0 = ?", value)
-		}
-	}
-	if fieldIndex <= 1 && 1 < fieldIndex+len(fieldValues) {
-		value := fieldValues[1-fieldIndex]
-		if value == "" {
-			selectQuery = selectQuery.Where("v1 LIKE '%'")
-			deleteQuery = deleteQuery.Where("v1 LIKE '%'")
-		} else {
-			selectQuery = selectQuery.Where("v1 = ?", value)
-			deleteQuery = deleteQuery.Where("v1 = ?", value)
-		}
-	}
-	if fieldIndex <= 2 && 2 < fieldIndex+len(fieldValues) {
-		value := fieldValues[2-fieldIndex]
-		if value == "" {
-			selectQuery = selectQuery.Where("v2 LIKE '%'")
-			deleteQuery = deleteQuery.Where("v2 LIKE '%'")
-		} else {
-			selectQuery = selectQuery.Where("v2 = ?", value)
-			deleteQuery = deleteQuery.Where("v2 = ?", value)
-		}
-	}
-	if fieldIndex <= 3 && 3 < fieldIndex+len(fieldValues) {
-		value := fieldValues[3-fieldIndex]
-		if value == "" {
-			selectQuery = selectQuery.Where("v3 LIKE '%'")
-			deleteQuery = deleteQuery.Where("v3 LIKE '%'")
-		} else {
-			selectQuery = selectQuery.Where("v3 = ?", value)
-			deleteQuery = deleteQuery.Where("v3 = ?", value)
-		}
-	}
-	if fieldIndex <= 4 && 4 < fieldIndex+len(fieldValues) {
-		value := fieldValues[4-fieldIndex]
-		if value == "" {
-			selectQuery = selectQuery.Where("v4 LIKE '%'")
-			deleteQuery = deleteQuery.Where("v4 LIKE '%'")
-		} else {
-			selectQuery = selectQuery.Where("v4 = ?", value)
-			deleteQuery = deleteQuery.Where("v4 = ?", value)
-		}
-	}
-	if fieldIndex <= 5 && 5 < fieldIndex+len(fieldValues) {
-		value := fieldValues[5-fieldIndex]
-		if value == "" {
-			selectQuery = selectQuery.Where("v5 LIKE '%'")
-			deleteQuery = deleteQuery.Where("v5 LIKE '%'")
-		} else {
-			selectQuery = selectQuery.Where("v5 = ?", value)
-			deleteQuery = deleteQuery.Where("v5 = ?", value)
-		}
-	}
-
-	// store old policies
-	if err := selectQuery.Scan(context.Background()); err != nil {
-		if err := tx.Rollback(); err != nil {
-			return nil, err
-		}
+	filter, err := a.fieldFilter(ptype, fieldIndex, fieldValues...)
+	if err != nil {
 		return nil, err
 	}
 
-	// delete old policies
-	if _, err := deleteQuery.Exec(context.Background()); err != nil {
-		if err := tx.Rollback(); err != nil {
-			return nil, err
+	var oldPolicies []CasbinPolicy
+	change := policyChange{method: UpdateForUpdateFilteredPolicies, sec: sec, ptype: ptype, newRules: newRules, fieldIndex: fieldIndex, fieldValues: fieldValues}
+	err = a.writeInTx(ctx, &change, func(ctx context.Context, tx bun.Tx) error {
+		// store old policies
+		var err error
+		oldPolicies, err = a.selectPolicies(ctx, tx.NewSelect().ApplyQueryBuilder(filter))
+		if err != nil {
+			return err
 		}
-		return nil, err
-	}
-
-	// create new policies
-	if _, err := tx.NewInsert().
-		Model(&newPolicies).
-		Exec(context.Background()); err != nil {
-		if err := tx.Rollback(); err != nil {
-			return nil, err
+		change.removed, change.added = oldPolicies, newPolicies
+
+		// delete old policies
+		if _, err := tx.NewDelete().
+			Model((*CasbinPolicy)(nil)).
+			ModelTableExpr(a.tableExpr()).
+			ApplyQueryBuilder(filter).
+			Exec(ctx); err != nil {
+			return err
 		}
+
+		// create new policies
+		return a.insertPolicies(ctx, tx, newPolicies)
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	out := make([][]string, 0, len(oldPolicies))
 	for _, policy := range oldPolicies {
-		out = append(out, policy.toSlice())
+		out = append(out, policy.filterValues())
 	}
 
-	return out, tx.Commit()
+	return out, nil
 }
@@ -0,0 +1,200 @@
+package casbinbunadapter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type auditContextKey int
+
+const (
+	actorKey auditContextKey = iota
+	reasonKey
+)
+
+// ContextWithActor returns the context which records the actor of the writes in the audit table.
+// Pass it to the methods of the adapter created by NewCtxAdapter.
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// ContextWithReason returns the context which records the reason of the writes in the audit table.
+func ContextWithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonKey, reason)
+}
+
+func contextString(ctx context.Context, key auditContextKey) string {
+	value, _ := ctx.Value(key).(string)
+	return value
+}
+
+// AuditEntry is a change of a policy rule recorded in the audit table.
+type AuditEntry struct {
+	bun.BaseModel `bun:"casbin_policies_audit,alias:au"`
+	ID            int64 `bun:"id,pk,autoincrement"`
+	// Operation is the method of the adapter, such as "AddPolicy" or "SavePolicy".
+	Operation string `bun:"operation"`
+	PType     string `bun:"ptype"`
+	// Subject is the first value of the rule, which is the subject of most models.
+	Subject string `bun:"subject"`
+	// OldValues is the rule removed or updated, and NewValues is the rule added or updated.
+	// OldValues is nil when the rule has been added, and NewValues is nil when the rule has been removed.
+	OldValues []string  `bun:"old_values"`
+	NewValues []string  `bun:"new_values"`
+	Actor     string    `bun:"actor"`
+	Reason    string    `bun:"reason"`
+	CreatedAt time.Time `bun:"created_at"`
+}
+
+// auditTable is the model to create the audit table,
+// whose columns of the values and the reason are created separately since their types depend on the dialect.
+type auditTable struct {
+	bun.BaseModel `bun:"casbin_policies_audit"`
+	ID            int64     `bun:"id,pk,autoincrement"`
+	Operation     string    `bun:"operation,type:varchar(100),notnull"`
+	PType         string    `bun:"ptype,type:varchar(100),notnull"`
+	Actor         string    `bun:"actor,type:varchar(255)"`
+	CreatedAt     time.Time `bun:"created_at,notnull"`
+}
+
+// WithAudit makes the adapter record every change of the policy rules in the audit table,
+// in the same transaction as the write.
+// The actor and the reason are taken from the context set by ContextWithActor and ContextWithReason.
+func WithAudit() adapterOption {
+	return func(a *bunAdapter) {
+		a.audit = true
+	}
+}
+
+func (a *bunAdapter) auditTableName() string {
+	return a.tableName + "_audit"
+}
+
+// createAuditTable creates the audit table unless it exists.
+func (a *bunAdapter) createAuditTable(ctx context.Context) error {
+	return a.createTableIfNotExists(ctx, a.createAuditTableQuery(), a.auditTableName())
+}
+
+func (a *bunAdapter) createAuditTableQuery() *bun.CreateTableQuery {
+	return a.db.NewCreateTable().
+		Model((*auditTable)(nil)).
+		ModelTableExpr("?", bun.Ident(a.auditTableName())).
+		ColumnExpr("subject ?", bun.Safe(a.columnType)).
+		ColumnExpr("old_values ?", bun.Safe(a.textType())).
+		ColumnExpr("new_values ?", bun.Safe(a.textType())).
+		ColumnExpr("reason ?", bun.Safe(a.textType())).
+		IfNotExists()
+}
+
+// recordAudit inserts the audit entries of the change with db, which is the transaction of the write.
+func (a *bunAdapter) recordAudit(ctx context.Context, db bun.IDB, change *policyChange) error {
+	entries := a.auditEntries(change)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	actor, reason := contextString(ctx, actorKey), contextString(ctx, reasonKey)
+	now := time.Now().UTC()
+	for i := range entries {
+		entries[i].Actor = actor
+		entries[i].Reason = reason
+		entries[i].CreatedAt = now
+	}
+
+	for _, batch := range chunk(entries, a.batchSizeOf(db)) {
+		if _, err := db.NewInsert().
+			Model(&batch).
+			ModelTableExpr("?", bun.Ident(a.auditTableName())).
+			Exec(ctx); err != nil {
+			return wrapError(err)
+		}
+	}
+	return nil
+}
+
+// auditEntries returns the entries of the rules changed by the change, one per rule.
+func (a *bunAdapter) auditEntries(change *policyChange) []AuditEntry {
+	operation := strings.TrimPrefix(string(change.method), "UpdateFor")
+	newEntry := func(ptype string, oldValues, newValues []string) AuditEntry {
+		entry := AuditEntry{Operation: operation, PType: ptype, OldValues: oldValues, NewValues: newValues}
+		switch {
+		case len(newValues) > 0:
+			entry.Subject = newValues[0]
+		case len(oldValues) > 0:
+			entry.Subject = oldValues[0]
+		}
+		return entry
+	}
+
+	var entries []AuditEntry
+	switch change.method {
+	case UpdateForAddPolicy, UpdateForAddPolicies:
+		for _, rule := range change.rules {
+			entries = append(entries, newEntry(change.ptype, nil, rule))
+		}
+	case UpdateForRemovePolicy, UpdateForRemovePolicies:
+		for _, rule := range change.rules {
+			entries = append(entries, newEntry(change.ptype, rule, nil))
+		}
+	case UpdateForUpdatePolicy, UpdateForUpdatePolicies:
+		for i := range change.rules {
+			entries = append(entries, newEntry(change.ptype, change.rules[i], change.newRules[i]))
+		}
+	default:
+		for _, policy := range change.removed {
+			entries = append(entries, newEntry(policy.PType, policy.filterValues(), nil))
+		}
+		for _, policy := range change.added {
+			entries = append(entries, newEntry(policy.PType, nil, policy.filterValues()))
+		}
+	}
+	return entries
+}
+
+// AuditFilter selects the audit entries. The zero value of each field matches all the entries.
+type AuditFilter struct {
+	Subject string
+	PType   string
+	// Since and Until select the entries recorded at or after Since and before Until.
+	Since time.Time
+	Until time.Time
+	// Limit is the maximum number of the entries.
+	Limit int
+}
+
+// AuditEntries returns the audit entries matching the filter, from the oldest.
+// It returns ErrAuditNotFound without WithAudit, since the audit table may not exist.
+func (a *bunAdapter) AuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	if !a.audit {
+		return nil, fmt.Errorf("%w: the adapter is created without WithAudit", ErrAuditNotFound)
+	}
+
+	var entries []AuditEntry
+	query := a.db.NewSelect().
+		Model(&entries).
+		ModelTableExpr("? AS au", bun.Ident(a.auditTableName())).
+		Order("id")
+	if filter.Subject != "" {
+		query = query.Where("subject = ?", filter.Subject)
+	}
+	if filter.PType != "" {
+		query = query.Where("ptype = ?", filter.PType)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until.UTC())
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if err := query.Scan(ctx); err != nil {
+		return nil, wrapError(err)
+	}
+	return entries, nil
+}
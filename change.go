@@ -0,0 +1,117 @@
+package casbinbunadapter
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// policyChange is a write of the policy, which is recorded in the same transaction.
+type policyChange struct {
+	method      UpdateType
+	sec         string
+	ptype       string
+	rules       [][]string
+	newRules    [][]string
+	fieldIndex  int
+	fieldValues []string
+	// removed and added are the policies removed and added by the writes which do not know them in advance,
+	// which are RemoveFilteredPolicy, UpdateFilteredPolicies and SavePolicy. They are only set for the audit and the history.
+	removed []CasbinPolicy
+	added   []CasbinPolicy
+}
+
+// message returns the WatcherMessage of the change made by the watcher of id.
+func (c policyChange) message(id string) WatcherMessage {
+	return WatcherMessage{
+		Method:      c.method,
+		ID:          id,
+		Sec:         c.sec,
+		PType:       c.ptype,
+		Rules:       c.rules,
+		NewRules:    c.newRules,
+		FieldIndex:  c.fieldIndex,
+		FieldValues: c.fieldValues,
+	}
+}
+
+// changeRecorder records the writes of the adapter in their transactions,
+// which is implemented by the watchers.
+type changeRecorder interface {
+	recordChange(ctx context.Context, db bun.IDB, change policyChange) error
+}
+
+// setWatcher attaches the watcher to the adapter, replacing the previous one.
+func (a *bunAdapter) setWatcher(w changeRecorder) {
+	a.watcherMu.Lock()
+	defer a.watcherMu.Unlock()
+	a.watcher = w
+}
+
+// unsetWatcher detaches the watcher unless it has been replaced.
+func (a *bunAdapter) unsetWatcher(w changeRecorder) {
+	a.watcherMu.Lock()
+	defer a.watcherMu.Unlock()
+	if a.watcher == w {
+		a.watcher = nil
+	}
+}
+
+func (a *bunAdapter) currentWatcher() changeRecorder {
+	a.watcherMu.RLock()
+	defer a.watcherMu.RUnlock()
+	return a.watcher
+}
+
+// recordsChanges reports whether the writes are recorded, in which case they are run in a transaction.
+func (a *bunAdapter) recordsChanges() bool {
+	return a.recordsPolicies() || a.currentWatcher() != nil
+}
+
+// recordsPolicies reports whether the policies changed by the writes are recorded,
+// in which case the writes set the policies removed and added to the change.
+func (a *bunAdapter) recordsPolicies() bool {
+	return a.audit || a.history
+}
+
+// recordChange records the change with db, which is the transaction of the write.
+func (a *bunAdapter) recordChange(ctx context.Context, db bun.IDB, change *policyChange) error {
+	if a.audit {
+		if err := a.recordAudit(ctx, db, change); err != nil {
+			return err
+		}
+	}
+	if a.history {
+		if err := a.recordHistory(ctx, db, change); err != nil {
+			return err
+		}
+	}
+	if w := a.currentWatcher(); w != nil {
+		return w.recordChange(ctx, db, *change)
+	}
+	return nil
+}
+
+// write runs fn and records the change in the same transaction.
+// Without a watcher, the audit or the history, no change is recorded and fn is run without a transaction.
+func (a *bunAdapter) write(ctx context.Context, change *policyChange, fn func(ctx context.Context, db bun.IDB) error) error {
+	if !a.recordsChanges() {
+		return a.retry(ctx, func(ctx context.Context) error {
+			return fn(ctx, a.db)
+		})
+	}
+	return a.writeInTx(ctx, change, func(ctx context.Context, tx bun.Tx) error {
+		return fn(ctx, tx)
+	})
+}
+
+// writeInTx runs fn in a transaction with runInTx, and records the change in the same transaction.
+// fn may set the policies removed and added to the change.
+func (a *bunAdapter) writeInTx(ctx context.Context, change *policyChange, fn func(ctx context.Context, tx bun.Tx) error) error {
+	return a.runInTx(ctx, func(ctx context.Context, tx bun.Tx) error {
+		if err := fn(ctx, tx); err != nil {
+			return err
+		}
+		return a.recordChange(ctx, tx, change)
+	})
+}
@@ -5,14 +5,23 @@ package casbinbunadapter
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/casbin/casbin/v2/model"
 	"github.com/casbin/casbin/v2/persist"
+	"github.com/uptrace/bun"
 )
 
 var (
 	// check if the ctxBunAdapter implements the ContextAdapter interface
 	_ persist.ContextAdapter = (*ctxBunAdapter)(nil) // Ensure ctxBunAdapter
+	// check if the ctxBunAdapter implements the ContextBatchAdapter interface
+	_ persist.ContextBatchAdapter = (*ctxBunAdapter)(nil)
+	// check if the ctxBunAdapter implements the ContextUpdatableAdapter interface
+	_ persist.ContextUpdatableAdapter = (*ctxBunAdapter)(nil)
+	// check if the ctxBunAdapter implements the ContextFilteredAdapter interface
+	_ persist.ContextFilteredAdapter = (*ctxBunAdapter)(nil)
 )
 
 type ctxBunAdapter struct {
@@ -27,55 +36,125 @@ func NewCtxAdapter(driverName string, dataSourceName string, opts ...adapterOpti
 	return &ctxBunAdapter{adapter}, nil
 }
 
-// executeWithContext is a helper function to execute a function with context and return the result or error.
-func executeWithContext(ctx context.Context, fn func() error) error {
-	done := make(chan error)
-	go func() {
-		done <- fn()
-	}()
+func NewCtxAdapterWithSqlDB(sqlDB *sql.DB, driverName string, opts ...adapterOption) (*ctxBunAdapter, error) {
+	adapter, err := NewAdapterWithSqlDB(sqlDB, driverName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &ctxBunAdapter{adapter}, nil
+}
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case err := <-done:
-		return err
+func NewCtxAdapterWithBunDB(db *bun.DB, opts ...adapterOption) (*ctxBunAdapter, error) {
+	adapter, err := NewAdapterWithBunDB(db, opts...)
+	if err != nil {
+		return nil, err
 	}
+	return &ctxBunAdapter{adapter}, nil
 }
 
 // LoadPolicyCtx loads all policy rules from the storage with context.
+// The context is passed down to the queries, so cancelling it aborts the running query.
 func (a *ctxBunAdapter) LoadPolicyCtx(ctx context.Context, model model.Model) error {
-	return executeWithContext(ctx, func() error {
-		return a.LoadPolicy(model)
-	})
+	return a.loadPolicy(ctx, model)
 }
 
 // SavePolicyCtx saves all policy rules to the storage with context.
 func (a *ctxBunAdapter) SavePolicyCtx(ctx context.Context, model model.Model) error {
-	return executeWithContext(ctx, func() error {
-		return a.SavePolicy(model)
-	})
+	return a.savePolicy(ctx, model)
+}
+
+// SavePolicyWithResultCtx saves all policy rules to the storage with context,
+// and returns the number of the rows added, removed and left unchanged.
+func (a *ctxBunAdapter) SavePolicyWithResultCtx(ctx context.Context, model model.Model) (SaveResult, error) {
+	return a.savePolicyWithResult(ctx, model)
 }
 
 // AddPolicyCtx adds a policy rule to the storage with context.
 // This is part of the Auto-Save feature.
 func (a *ctxBunAdapter) AddPolicyCtx(ctx context.Context, sec string, ptype string, rule []string) error {
-	return executeWithContext(ctx, func() error {
-		return a.AddPolicy(sec, ptype, rule)
-	})
+	return a.addPolicy(ctx, sec, ptype, rule)
 }
 
 // RemovePolicyCtx removes a policy rule from the storage with context.
 // This is part of the Auto-Save feature.
 func (a *ctxBunAdapter) RemovePolicyCtx(ctx context.Context, sec string, ptype string, rule []string) error {
-	return executeWithContext(ctx, func() error {
-		return a.RemovePolicy(sec, ptype, rule)
-	})
+	return a.removePolicy(ctx, sec, ptype, rule)
+}
+
+// RemovePolicyWithResultCtx removes a policy rule from the storage with context,
+// and returns the number of the rows removed.
+func (a *ctxBunAdapter) RemovePolicyWithResultCtx(ctx context.Context, sec string, ptype string, rule []string) (int, error) {
+	return a.removePolicyWithResult(ctx, sec, ptype, rule)
 }
 
 // RemoveFilteredPolicyCtx removes policy rules that match the filter from the storage with context.
 // This is part of the Auto-Save feature.
 func (a *ctxBunAdapter) RemoveFilteredPolicyCtx(ctx context.Context, sec string, ptype string, fieldIndex int, fieldValues ...string) error {
-	return executeWithContext(ctx, func() error {
-		return a.RemoveFilteredPolicy(sec, ptype, fieldIndex, fieldValues...)
-	})
+	return a.removeFilteredPolicy(ctx, sec, ptype, fieldIndex, fieldValues...)
+}
+
+// AddPoliciesCtx adds policy rules to the storage with context.
+// This is part of the Auto-Save feature.
+func (a *ctxBunAdapter) AddPoliciesCtx(ctx context.Context, sec string, ptype string, rules [][]string) error {
+	return a.addPolicies(ctx, sec, ptype, rules)
+}
+
+// RemovePoliciesCtx removes policy rules from the storage with context.
+// This is part of the Auto-Save feature.
+func (a *ctxBunAdapter) RemovePoliciesCtx(ctx context.Context, sec string, ptype string, rules [][]string) error {
+	return a.removePolicies(ctx, sec, ptype, rules)
+}
+
+// RemovePoliciesWithResultCtx removes policy rules from the storage with context,
+// and returns the number of the rows removed.
+func (a *ctxBunAdapter) RemovePoliciesWithResultCtx(ctx context.Context, sec string, ptype string, rules [][]string) (int, error) {
+	return a.removePoliciesWithResult(ctx, sec, ptype, rules)
+}
+
+// UpdatePolicyCtx updates a policy rule from storage with context.
+// This is part of the Auto-Save feature.
+func (a *ctxBunAdapter) UpdatePolicyCtx(ctx context.Context, sec string, ptype string, oldRule, newRule []string) error {
+	return a.updatePolicy(ctx, sec, ptype, oldRule, newRule)
+}
+
+// UpdatePolicyWithResultCtx updates a policy rule from storage with context,
+// and returns the number of the rows updated.
+func (a *ctxBunAdapter) UpdatePolicyWithResultCtx(ctx context.Context, sec string, ptype string, oldRule, newRule []string) (int, error) {
+	return a.updatePolicyWithResult(ctx, sec, ptype, oldRule, newRule)
+}
+
+// UpdatePoliciesCtx updates some policy rules to storage with context.
+func (a *ctxBunAdapter) UpdatePoliciesCtx(ctx context.Context, sec string, ptype string, oldRules, newRules [][]string) error {
+	return a.updatePolicies(ctx, sec, ptype, oldRules, newRules)
+}
+
+// UpdatePoliciesWithResultCtx updates some policy rules to storage with context,
+// and returns the number of the rows updated.
+func (a *ctxBunAdapter) UpdatePoliciesWithResultCtx(ctx context.Context, sec string, ptype string, oldRules, newRules [][]string) (int, error) {
+	return a.updatePoliciesWithResult(ctx, sec, ptype, oldRules, newRules)
+}
+
+// UpdateFilteredPoliciesCtx deletes old rules and adds new rules with context.
+func (a *ctxBunAdapter) UpdateFilteredPoliciesCtx(ctx context.Context, sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+	return a.updateFilteredPolicies(ctx, sec, ptype, newRules, fieldIndex, fieldValues...)
+}
+
+// LoadFilteredPolicyCtx loads only policy rules that match the filter with context.
+func (a *ctxBunAdapter) LoadFilteredPolicyCtx(ctx context.Context, model model.Model, filter interface{}) error {
+	return a.loadFilteredPolicy(ctx, model, filter)
+}
+
+// IsFilteredCtx returns true if the loaded policy has been filtered.
+func (a *ctxBunAdapter) IsFilteredCtx(ctx context.Context) bool {
+	return a.IsFiltered()
+}
+
+// LoadPolicyAtCtx loads the policy rules stored at the time with context.
+func (a *ctxBunAdapter) LoadPolicyAtCtx(ctx context.Context, model model.Model, at time.Time) error {
+	return a.loadPolicyAt(ctx, model, at)
+}
+
+// RestoreToCtx rewrites the stored policy rules to the ones stored at the time with context.
+func (a *ctxBunAdapter) RestoreToCtx(ctx context.Context, at time.Time) error {
+	return a.restoreTo(ctx, at)
 }
@@ -3,26 +3,17 @@ package casbinbunadapter
 import (
 	"context"
 	"testing"
-	"time"
 
-	"github.com/agiledragon/gomonkey/v2"
 	"github.com/casbin/casbin/v2"
 	"github.com/stretchr/testify/assert"
 )
 
-func mockExecuteWithContextTimeOut(ctx context.Context, fn func() error) error {
-	done := make(chan error)
-	go func() {
-		time.Sleep(500 * time.Microsecond)
-		done <- fn()
-	}()
-
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case err := <-done:
-		return err
-	}
+// canceledContext returns a context which is already cancelled,
+// so that any query run with it must be aborted.
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
 }
 
 func clearDBPolicy() (*casbin.Enforcer, *ctxBunAdapter) {
@@ -60,11 +51,8 @@ func TestCtxBunAdapter_LoadPolicyCtx(t *testing.T) {
 		},
 	)
 
-	var p = gomonkey.ApplyFunc(executeWithContext, mockExecuteWithContextTimeOut)
-	defer p.Reset()
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Microsecond)
-	defer cancel()
-	assert.EqualError(t, ca.LoadPolicyCtx(ctx, e.GetModel()), "context deadline exceeded")
+	ctx := canceledContext()
+	assert.ErrorIs(t, ca.LoadPolicyCtx(ctx, e.GetModel()), context.Canceled)
 }
 
 func TestCtxBunAdapter_SavePolicyCtx(t *testing.T) {
@@ -82,11 +70,8 @@ func TestCtxBunAdapter_SavePolicyCtx(t *testing.T) {
 		},
 	)
 
-	var p = gomonkey.ApplyFunc(executeWithContext, mockExecuteWithContextTimeOut)
-	defer p.Reset()
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Microsecond)
-	defer cancel()
-	assert.EqualError(t, ca.SavePolicyCtx(ctx, e.GetModel()), "context deadline exceeded")
+	ctx := canceledContext()
+	assert.ErrorIs(t, ca.SavePolicyCtx(ctx, e.GetModel()), context.Canceled)
 }
 
 func TestCtxBunAdapter_AddPolicyCtx(t *testing.T) {
@@ -104,11 +89,17 @@ func TestCtxBunAdapter_AddPolicyCtx(t *testing.T) {
 		},
 	)
 
-	var p = gomonkey.ApplyFunc(executeWithContext, mockExecuteWithContextTimeOut)
-	defer p.Reset()
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Microsecond)
-	defer cancel()
-	assert.EqualError(t, ca.AddPolicyCtx(ctx, "p", "p", []string{"alice", "data2", "read"}), "context deadline exceeded")
+	ctx := canceledContext()
+	assert.ErrorIs(t, ca.AddPolicyCtx(ctx, "p", "p", []string{"alice", "data2", "read"}), context.Canceled)
+	// the cancelled write must not be committed
+	_ = e.LoadPolicy()
+	testGetPolicy(
+		t,
+		e,
+		[][]string{
+			{"alice", "data1", "read"},
+		},
+	)
 }
 
 func TestCtxBunAdapter_RemovePolicyCtx(t *testing.T) {
@@ -126,11 +117,8 @@ func TestCtxBunAdapter_RemovePolicyCtx(t *testing.T) {
 		},
 	)
 
-	var p = gomonkey.ApplyFunc(executeWithContext, mockExecuteWithContextTimeOut)
-	defer p.Reset()
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Microsecond)
-	defer cancel()
-	assert.EqualError(t, ca.RemovePolicyCtx(ctx, "p", "p", []string{"alice", "data2", "read"}), "context deadline exceeded")
+	ctx := canceledContext()
+	assert.ErrorIs(t, ca.RemovePolicyCtx(ctx, "p", "p", []string{"alice", "data2", "read"}), context.Canceled)
 }
 
 func TestCtxBunAdapter_RemoveFilteredPolicyCtx(t *testing.T) {
@@ -149,9 +137,137 @@ func TestCtxBunAdapter_RemoveFilteredPolicyCtx(t *testing.T) {
 		},
 	)
 
-	var p = gomonkey.ApplyFunc(executeWithContext, mockExecuteWithContextTimeOut)
-	defer p.Reset()
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Microsecond)
-	defer cancel()
-	assert.EqualError(t, ca.RemoveFilteredPolicyCtx(ctx, "p", "p", 0, "alice"), "context deadline exceeded")
+	ctx := canceledContext()
+	assert.ErrorIs(t, ca.RemoveFilteredPolicyCtx(ctx, "p", "p", 0, "alice"), context.Canceled)
+}
+
+func TestCtxBunAdapter_AddPoliciesCtx(t *testing.T) {
+	e, ca := clearDBPolicy()
+
+	if err := ca.AddPoliciesCtx(context.Background(), "p", "p", [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}}); err != nil {
+		t.Fatalf("failed to add policies: %v", err)
+	}
+	_ = e.LoadPolicy()
+	testGetPolicy(
+		t,
+		e,
+		[][]string{
+			{"alice", "data1", "read"},
+			{"bob", "data2", "write"},
+		},
+	)
+
+	ctx := canceledContext()
+	assert.ErrorIs(t, ca.AddPoliciesCtx(ctx, "p", "p", [][]string{{"alice", "data2", "read"}}), context.Canceled)
+}
+
+func TestCtxBunAdapter_RemovePoliciesCtx(t *testing.T) {
+	e, ca := clearDBPolicy()
+
+	_ = ca.AddPoliciesCtx(context.Background(), "p", "p", [][]string{{"alice", "data1", "read"}, {"alice", "data2", "read"}, {"bob", "data1", "read"}})
+	_ = ca.RemovePoliciesCtx(context.Background(), "p", "p", [][]string{{"alice", "data1", "read"}, {"alice", "data2", "read"}})
+	_ = e.LoadPolicy()
+	testGetPolicy(
+		t,
+		e,
+		[][]string{
+			{"bob", "data1", "read"},
+		},
+	)
+
+	ctx := canceledContext()
+	assert.ErrorIs(t, ca.RemovePoliciesCtx(ctx, "p", "p", [][]string{{"bob", "data1", "read"}}), context.Canceled)
+}
+
+func TestCtxBunAdapter_UpdatePolicyCtx(t *testing.T) {
+	e, ca := clearDBPolicy()
+
+	_ = ca.AddPolicyCtx(context.Background(), "p", "p", []string{"alice", "data1", "read"})
+	_ = ca.UpdatePolicyCtx(context.Background(), "p", "p", []string{"alice", "data1", "read"}, []string{"alice", "data1", "write"})
+	_ = e.LoadPolicy()
+	testGetPolicy(
+		t,
+		e,
+		[][]string{
+			{"alice", "data1", "write"},
+		},
+	)
+
+	ctx := canceledContext()
+	assert.ErrorIs(t, ca.UpdatePolicyCtx(ctx, "p", "p", []string{"alice", "data1", "write"}, []string{"alice", "data1", "read"}), context.Canceled)
+}
+
+func TestCtxBunAdapter_UpdatePoliciesCtx(t *testing.T) {
+	e, ca := clearDBPolicy()
+
+	_ = ca.AddPoliciesCtx(context.Background(), "p", "p", [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+	_ = ca.UpdatePoliciesCtx(
+		context.Background(),
+		"p",
+		"p",
+		[][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}},
+		[][]string{{"alice", "data1", "write"}, {"bob", "data2", "read"}},
+	)
+	_ = e.LoadPolicy()
+	testGetPolicy(
+		t,
+		e,
+		[][]string{
+			{"alice", "data1", "write"},
+			{"bob", "data2", "read"},
+		},
+	)
+
+	ctx := canceledContext()
+	assert.ErrorIs(t, ca.UpdatePoliciesCtx(ctx, "p", "p", [][]string{{"alice", "data1", "write"}}, [][]string{{"alice", "data1", "read"}}), context.Canceled)
+}
+
+func TestCtxBunAdapter_UpdateFilteredPoliciesCtx(t *testing.T) {
+	e, ca := clearDBPolicy()
+
+	_ = ca.AddPoliciesCtx(context.Background(), "p", "p", [][]string{{"alice", "data1", "read"}, {"alice", "data2", "read"}, {"bob", "data1", "read"}})
+	_, _ = ca.UpdateFilteredPoliciesCtx(context.Background(), "p", "p", [][]string{{"alice", "data3", "write"}}, 0, "alice")
+	_ = e.LoadPolicy()
+	testGetPolicy(
+		t,
+		e,
+		[][]string{
+			{"bob", "data1", "read"},
+			{"alice", "data3", "write"},
+		},
+	)
+
+	ctx := canceledContext()
+	_, err := ca.UpdateFilteredPoliciesCtx(ctx, "p", "p", [][]string{{"bob", "data3", "write"}}, 0, "bob")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestNewCtxAdapterWithSqlDB(t *testing.T) {
+	sqlDB, err := openSqlDB("mysql", "root:root@tcp(127.0.0.1:3306)/test")
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	ca, err := NewCtxAdapterWithSqlDB(sqlDB, "mysql", WithDebugMode())
+	if err != nil {
+		t.Fatalf("failed to create adapter: %v", err)
+	}
+	_, err = casbin.NewEnforcer("testdata/rbac_model.conf", ca)
+	assert.NoError(t, err)
+}
+
+func TestNewCtxAdapterWithBunDB(t *testing.T) {
+	sqlDB, err := openSqlDB("mysql", "root:root@tcp(127.0.0.1:3306)/test")
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	db, err := openBunDB(sqlDB, "mysql")
+	if err != nil {
+		t.Fatalf("failed to open bun db: %v", err)
+	}
+	ca, err := NewCtxAdapterWithBunDB(db, WithDebugMode())
+	if err != nil {
+		t.Fatalf("failed to create adapter: %v", err)
+	}
+	_, err = casbin.NewEnforcer("testdata/rbac_model.conf", ca)
+	assert.NoError(t, err)
 }
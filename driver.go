@@ -0,0 +1,125 @@
+package casbinbunadapter
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sync"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/mssqldialect"
+	"github.com/uptrace/bun/dialect/mysqldialect"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+	"github.com/uptrace/bun/driver/sqliteshim"
+	"github.com/uptrace/bun/schema"
+)
+
+// OpenFunc opens the database of the data source name.
+type OpenFunc func(dataSourceName string) (*sql.DB, error)
+
+// DialectFunc returns the bun dialect of the database.
+type DialectFunc func() schema.Dialect
+
+type registeredDriver struct {
+	open    OpenFunc
+	dialect DialectFunc
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]registeredDriver{}
+)
+
+func init() {
+	mysqlDriver := registeredDriver{open: sqlOpen("mysql"), dialect: func() schema.Dialect { return mysqldialect.New() }}
+	pgDriver := registeredDriver{open: openPostgres, dialect: func() schema.Dialect { return pgdialect.New() }}
+	pgxDriver := registeredDriver{open: sqlOpen("pgx"), dialect: func() schema.Dialect { return pgdialect.New() }}
+	mssqlDriver := registeredDriver{open: sqlOpen("mssql"), dialect: func() schema.Dialect { return mssqldialect.New() }}
+	sqlserverDriver := registeredDriver{open: sqlOpen("sqlserver"), dialect: func() schema.Dialect { return mssqldialect.New() }}
+	sqliteDriver := registeredDriver{open: sqlOpen(sqliteshim.ShimName), dialect: func() schema.Dialect { return sqlitedialect.New() }}
+
+	drivers["mysql"] = mysqlDriver
+	drivers["postgres"] = pgDriver
+	drivers["postgresql"] = pgDriver
+	// pgx is opened with its own driver, which must be imported by the caller
+	drivers["pgx"] = pgxDriver
+	drivers["mssql"] = mssqlDriver
+	drivers["sqlserver"] = sqlserverDriver
+	drivers["sqlite3"] = sqliteDriver
+	drivers["sqlite"] = sqliteDriver
+}
+
+// RegisterDriver registers the driver name for NewAdapter and NewAdapterWithSqlDB.
+// open opens the database of the data source name, and dialect returns the bun dialect of the database.
+// Registering a name which is already registered, including the built-in names, replaces it.
+func RegisterDriver(name string, open OpenFunc, dialect DialectFunc) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	drivers[name] = registeredDriver{open: open, dialect: dialect}
+}
+
+func lookupDriver(name string) (registeredDriver, error) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	d, ok := drivers[name]
+	if !ok {
+		return registeredDriver{}, fmt.Errorf("%w: %s", ErrUnsupportedDriver, name)
+	}
+	return d, nil
+}
+
+func sqlOpen(driverName string) OpenFunc {
+	return func(dataSourceName string) (*sql.DB, error) {
+		return sql.Open(driverName, dataSourceName)
+	}
+}
+
+func openPostgres(dataSourceName string) (*sql.DB, error) {
+	return sql.OpenDB(pgdriver.NewConnector(pgdriver.WithDSN(dataSourceName))), nil
+}
+
+func openSqlDB(driverName, dataSourceName string) (*sql.DB, error) {
+	d, err := lookupDriver(driverName)
+	if err != nil {
+		return nil, err
+	}
+	return d.open(dataSourceName)
+}
+
+// openBunDB wraps the sqlDB with the dialect of the driver name.
+// The driver name is detected from the driver of sqlDB when it is empty.
+func openBunDB(sqlDB *sql.DB, driverName string) (*bun.DB, error) {
+	if driverName == "" {
+		var err error
+		if driverName, err = detectDriverName(sqlDB); err != nil {
+			return nil, err
+		}
+	}
+	d, err := lookupDriver(driverName)
+	if err != nil {
+		return nil, err
+	}
+	return bun.NewDB(sqlDB, d.dialect()), nil
+}
+
+// detectDriverName returns the built-in driver name of the driver of sqlDB.
+// Other drivers, including the registered ones and the wrapped ones, need the driver name to be passed explicitly.
+func detectDriverName(sqlDB *sql.DB) (string, error) {
+	switch d := sqlDB.Driver().(type) {
+	case *mysql.MySQLDriver, mysql.MySQLDriver:
+		return "mysql", nil
+	case *pgdriver.Driver, pgdriver.Driver:
+		return "postgres", nil
+	case *mssql.Driver:
+		return "mssql", nil
+	default:
+		if reflect.TypeOf(d) == reflect.TypeOf(sqliteshim.Driver()) {
+			return "sqlite3", nil
+		}
+		return "", fmt.Errorf("%w: cannot detect the dialect of %T, pass the driver name", ErrUnsupportedDriver, d)
+	}
+}
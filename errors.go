@@ -0,0 +1,192 @@
+package casbinbunadapter
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+var (
+	// ErrDuplicatePolicy is returned when a policy rule which already exists in the storage is added.
+	ErrDuplicatePolicy = errors.New("policy already exists")
+	// ErrInvalidRule is returned when a policy rule or a filter does not fit in the columns of the table.
+	ErrInvalidRule = errors.New("invalid policy rule")
+	// ErrPolicyNotFound is returned when a policy rule to be removed or updated does not exist in the storage.
+	ErrPolicyNotFound = errors.New("policy not found")
+	// ErrFilteredPolicy is returned when a filtered policy is saved, since the model only holds a part of the stored rules.
+	ErrFilteredPolicy = errors.New("cannot save a filtered policy")
+	// ErrUnsupportedDriver is returned when the driver or its dialect is neither built in nor registered.
+	ErrUnsupportedDriver = errors.New("unsupported driver")
+	// ErrAuditNotFound is returned when the audit entries are requested without WithAudit.
+	ErrAuditNotFound = errors.New("audit trail not found")
+	// ErrHistoryNotFound is returned when the policy at a time is requested without WithHistory,
+	// or the history is empty or begins after the time.
+	ErrHistoryNotFound = errors.New("policy history not found")
+	// ErrDuplicateSnapshot is returned when a snapshot is taken with the name of an existing snapshot.
+	ErrDuplicateSnapshot = errors.New("snapshot already exists")
+	// ErrSnapshotNotFound is returned when a snapshot to be restored or deleted does not exist,
+	// or the adapter is created without WithSnapshots.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// ErrTransient is returned when the database fails temporarily, such as on a deadlock,
+	// so that the same operation may succeed when it is retried.
+	ErrTransient = errors.New("transient database failure")
+)
+
+// DBError is the error returned by the database, classified into one of the errors of this package.
+// Both Kind and Err can be checked with errors.Is and errors.As.
+type DBError struct {
+	// Kind is ErrDuplicatePolicy or ErrTransient.
+	Kind error
+	// Code is the native error code of the database, such as "1062" of MySQL or "23505" of PostgreSQL.
+	// It is empty when the error has no code.
+	Code string
+	// Err is the error returned by the driver.
+	Err error
+}
+
+func (e *DBError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+func (e *DBError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// ValueTooLongError is returned when a value of a policy rule is longer than its column,
+// whose length is set with WithColumnLength or WithColumnType.
+// It can be checked with errors.Is as ErrInvalidRule.
+type ValueTooLongError struct {
+	// Column is the name of the column, such as "v1".
+	Column string
+	// Value is the value which is too long.
+	Value string
+	// Length is the length of the value in characters.
+	Length int
+	// MaxLength is the maximum length of the column in characters.
+	MaxLength int
+}
+
+func (e *ValueTooLongError) Error() string {
+	return fmt.Sprintf("%v: the value of %s has %d characters, which exceed the column length of %d", ErrInvalidRule, e.Column, e.Length, e.MaxLength)
+}
+
+func (e *ValueTooLongError) Unwrap() error {
+	return ErrInvalidRule
+}
+
+const (
+	mysqlDuplicateEntry       = 1062
+	mysqlLockWaitTimeout      = 1205
+	mysqlDeadlock             = 1213
+	pgUniqueViolation         = "23505"
+	pgSerializationFailure    = "40001"
+	pgDeadlockDetected        = "40P01"
+	mssqlUniqueConstraint     = 2627
+	mssqlUniqueIndex          = 2601
+	mssqlDeadlock             = 1205
+	sqliteBusy                = 5
+	sqliteLocked              = 6
+	sqliteConstraintUnique    = 2067
+	sqliteConstraintPK        = 1555
+	sqliteUniqueFailedMessage = "UNIQUE constraint failed"
+	sqliteLockedMessage       = "database is locked"
+)
+
+// classifyError returns the error of this package corresponding to the driver error and the native error code.
+// The kind is nil when the error is not classified.
+func classifyError(err error) (kind error, code string) {
+	if err == nil {
+		return nil, ""
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		code := strconv.Itoa(int(mysqlErr.Number))
+		switch mysqlErr.Number {
+		case mysqlDuplicateEntry:
+			return ErrDuplicatePolicy, code
+		case mysqlDeadlock, mysqlLockWaitTimeout:
+			return ErrTransient, code
+		}
+		return nil, code
+	}
+	var pgErr pgdriver.Error
+	if errors.As(err, &pgErr) {
+		code := pgErr.Field('C')
+		switch code {
+		case pgUniqueViolation:
+			return ErrDuplicatePolicy, code
+		case pgSerializationFailure, pgDeadlockDetected:
+			return ErrTransient, code
+		}
+		return nil, code
+	}
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		code := strconv.Itoa(int(mssqlErr.Number))
+		switch mssqlErr.Number {
+		case mssqlUniqueConstraint, mssqlUniqueIndex:
+			return ErrDuplicatePolicy, code
+		case mssqlDeadlock:
+			return ErrTransient, code
+		}
+		return nil, code
+	}
+	// modernc.org/sqlite returns an error with the extended result code
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) {
+		code := strconv.Itoa(sqliteErr.Code())
+		switch {
+		case sqliteErr.Code() == sqliteConstraintUnique || sqliteErr.Code() == sqliteConstraintPK:
+			return ErrDuplicatePolicy, code
+		// the primary result code is the lowest byte of the extended one
+		case sqliteErr.Code()&0xff == sqliteBusy || sqliteErr.Code()&0xff == sqliteLocked:
+			return ErrTransient, code
+		}
+		return nil, code
+	}
+	// github.com/mattn/go-sqlite3 is only available with cgo, so its error is matched by the message
+	switch {
+	case strings.Contains(err.Error(), sqliteUniqueFailedMessage):
+		return ErrDuplicatePolicy, ""
+	case strings.Contains(err.Error(), sqliteLockedMessage):
+		return ErrTransient, ""
+	}
+	return nil, ""
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation returned by the driver.
+func isUniqueViolation(err error) bool {
+	kind, _ := classifyError(err)
+	return kind == ErrDuplicatePolicy
+}
+
+// wrapError wraps the driver error into DBError with the corresponding error of this package,
+// so that it can be checked with errors.Is while the driver error is still available with errors.As.
+// The errors which are not classified, or already wrapped, are returned as they are.
+func wrapError(err error) error {
+	var dbErr *DBError
+	if err == nil || errors.As(err, &dbErr) {
+		return err
+	}
+	kind, code := classifyError(err)
+	if kind == nil {
+		return err
+	}
+	return &DBError{Kind: kind, Code: code, Err: err}
+}
+
+// wrapTxError wraps the error of a transaction like wrapError, and also classifies driver.ErrBadConn as ErrTransient,
+// since the transaction is rolled back with the broken connection and can be run again.
+// A single statement failing with it is not retried, so that it is never applied twice.
+func wrapTxError(err error) error {
+	if errors.Is(err, driver.ErrBadConn) {
+		return &DBError{Kind: ErrTransient, Err: err}
+	}
+	return wrapError(err)
+}
@@ -0,0 +1,196 @@
+package casbinbunadapter
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/go-sql-driver/mysql"
+)
+
+func Test_isUniqueViolation(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "true when mysql returns duplicate entry",
+			err:  &mysql.MySQLError{Number: 1062},
+			want: true,
+		},
+		{
+			name: "false when mysql returns other error",
+			err:  &mysql.MySQLError{Number: 1146},
+			want: false,
+		},
+		{
+			name: "true when mssql returns unique constraint violation",
+			err:  mssql.Error{Number: 2627},
+			want: true,
+		},
+		{
+			name: "true when mssql returns unique index violation",
+			err:  mssql.Error{Number: 2601},
+			want: true,
+		},
+		{
+			name: "true when sqlite returns unique constraint failed",
+			err:  errors.New("UNIQUE constraint failed: casbin_policies.ptype"),
+			want: true,
+		},
+		{
+			name: "true when the driver error is wrapped",
+			err:  fmt.Errorf("wrapped: %w", &mysql.MySQLError{Number: 1062}),
+			want: true,
+		},
+		{
+			name: "false when error is nil",
+			err:  nil,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUniqueViolation(tt.err); got != tt.want {
+				t.Errorf("isUniqueViolation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_wrapError(t *testing.T) {
+	driverErr := &mysql.MySQLError{Number: 1062}
+	err := wrapError(driverErr)
+	if !errors.Is(err, ErrDuplicatePolicy) {
+		t.Errorf("errors.Is(err, ErrDuplicatePolicy) = false, want true")
+	}
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		t.Errorf("errors.As(err, *mysql.MySQLError) = false, want true")
+	}
+	var dbErr *DBError
+	if !errors.As(err, &dbErr) || dbErr.Code != "1062" {
+		t.Errorf("errors.As(err, *DBError) = %v, want the code 1062", dbErr)
+	}
+	if got := wrapError(err); got != err {
+		t.Errorf("wrapError() wrapped the error twice: %v", got)
+	}
+
+	otherErr := errors.New("other")
+	if got := wrapError(otherErr); got != otherErr {
+		t.Errorf("wrapError() = %v, want %v", got, otherErr)
+	}
+}
+
+func Test_wrapTxError(t *testing.T) {
+	// the transaction is rolled back with the broken connection, so it is retried
+	err := wrapTxError(fmt.Errorf("wrapped: %w", driver.ErrBadConn))
+	if !errors.Is(err, ErrTransient) || !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("wrapTxError() = %v, want %v wrapping %v", err, ErrTransient, driver.ErrBadConn)
+	}
+	// a single statement may have been applied, so it is not retried
+	if err := wrapError(driver.ErrBadConn); errors.Is(err, ErrTransient) {
+		t.Errorf("wrapError() = %v, want the error not to be %v", err, ErrTransient)
+	}
+
+	driverErr := &mysql.MySQLError{Number: 1213}
+	if err := wrapTxError(driverErr); !errors.Is(err, ErrTransient) {
+		t.Errorf("wrapTxError() = %v, want %v", err, ErrTransient)
+	}
+}
+
+type sqliteError struct {
+	code int
+}
+
+func (e sqliteError) Error() string {
+	return "sqlite error"
+}
+
+func (e sqliteError) Code() int {
+	return e.code
+}
+
+func Test_classifyError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantKind error
+		wantCode string
+	}{
+		{
+			name:     "mysql duplicate entry",
+			err:      &mysql.MySQLError{Number: 1062},
+			wantKind: ErrDuplicatePolicy,
+			wantCode: "1062",
+		},
+		{
+			name:     "mysql deadlock",
+			err:      &mysql.MySQLError{Number: 1213},
+			wantKind: ErrTransient,
+			wantCode: "1213",
+		},
+		{
+			name:     "mysql other error",
+			err:      &mysql.MySQLError{Number: 1146},
+			wantKind: nil,
+			wantCode: "1146",
+		},
+		{
+			name:     "mssql unique constraint violation",
+			err:      mssql.Error{Number: 2627},
+			wantKind: ErrDuplicatePolicy,
+			wantCode: "2627",
+		},
+		{
+			name:     "mssql deadlock",
+			err:      mssql.Error{Number: 1205},
+			wantKind: ErrTransient,
+			wantCode: "1205",
+		},
+		{
+			name:     "sqlite unique constraint",
+			err:      sqliteError{code: 2067},
+			wantKind: ErrDuplicatePolicy,
+			wantCode: "2067",
+		},
+		{
+			name:     "sqlite busy with the extended result code",
+			err:      sqliteError{code: 261},
+			wantKind: ErrTransient,
+			wantCode: "261",
+		},
+		{
+			name:     "sqlite database is locked without code",
+			err:      errors.New("database is locked"),
+			wantKind: ErrTransient,
+			wantCode: "",
+		},
+		{
+			name:     "bad connection, which is only transient in a transaction",
+			err:      fmt.Errorf("wrapped: %w", driver.ErrBadConn),
+			wantKind: nil,
+			wantCode: "",
+		},
+		{
+			name:     "other error",
+			err:      errors.New("other"),
+			wantKind: nil,
+			wantCode: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, code := classifyError(tt.err)
+			if kind != tt.wantKind {
+				t.Errorf("classifyError() kind = %v, want %v", kind, tt.wantKind)
+			}
+			if code != tt.wantCode {
+				t.Errorf("classifyError() code = %v, want %v", code, tt.wantCode)
+			}
+		})
+	}
+}
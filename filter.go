@@ -0,0 +1,50 @@
+package casbinbunadapter
+
+import (
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+// Filter defines the filtering rules for LoadFilteredPolicy.
+// Each field holds the values allowed for the corresponding column.
+// An empty field does not filter the column, and the non-empty fields are combined with AND.
+type Filter struct {
+	PType []string
+	V0    []string
+	V1    []string
+	V2    []string
+	V3    []string
+	V4    []string
+	V5    []string
+	// Extra holds the values allowed for the columns from v6, which are added by WithFieldCount.
+	Extra [][]string
+}
+
+// apply adds a WHERE ... IN clause to the query for every non-empty field of the filter.
+func (f Filter) apply(query *bun.SelectQuery) *bun.SelectQuery {
+	if len(f.PType) > 0 {
+		query = query.Where("? IN (?)", bun.Ident("ptype"), bun.In(f.PType))
+	}
+	for i, values := range append([][]string{f.V0, f.V1, f.V2, f.V3, f.V4, f.V5}, f.Extra...) {
+		if len(values) == 0 {
+			continue
+		}
+		query = query.Where("? IN (?)", bun.Ident(valueColumn(i)), bun.In(values))
+	}
+	return query
+}
+
+func toFilter(filter interface{}) (Filter, error) {
+	switch f := filter.(type) {
+	case Filter:
+		return f, nil
+	case *Filter:
+		if f == nil {
+			return Filter{}, nil
+		}
+		return *f, nil
+	default:
+		return Filter{}, fmt.Errorf("invalid filter type: %T", filter)
+	}
+}
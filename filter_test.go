@@ -0,0 +1,98 @@
+package casbinbunadapter
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/driver/sqliteshim"
+)
+
+func TestFilter_apply(t *testing.T) {
+	sqlDB, err := sql.Open(sqliteshim.ShimName, "file::memory:?cache=shared")
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	db := bun.NewDB(sqlDB, sqlitedialect.New())
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{
+			name:   "no condition when filter is empty",
+			filter: Filter{},
+			want:   `SELECT "cp"."id", "cp"."ptype", "cp"."v0", "cp"."v1", "cp"."v2", "cp"."v3", "cp"."v4", "cp"."v5" FROM "casbin_policies" AS "cp"`,
+		},
+		{
+			name: "conditions for each non-empty field",
+			filter: Filter{
+				PType: []string{"p"},
+				V0:    []string{"alice", "bob"},
+				V2:    []string{"read"},
+			},
+			want: `SELECT "cp"."id", "cp"."ptype", "cp"."v0", "cp"."v1", "cp"."v2", "cp"."v3", "cp"."v4", "cp"."v5" FROM "casbin_policies" AS "cp" WHERE ("ptype" IN ('p')) AND ("v0" IN ('alice', 'bob')) AND ("v2" IN ('read'))`,
+		},
+		{
+			name: "conditions for the extra columns",
+			filter: Filter{
+				V0:    []string{"alice"},
+				Extra: [][]string{nil, {"allow"}},
+			},
+			want: `SELECT "cp"."id", "cp"."ptype", "cp"."v0", "cp"."v1", "cp"."v2", "cp"."v3", "cp"."v4", "cp"."v5" FROM "casbin_policies" AS "cp" WHERE ("v0" IN ('alice')) AND ("v7" IN ('allow'))`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := db.NewSelect().Model((*CasbinPolicy)(nil))
+			got := tt.filter.apply(query).String()
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("apply() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_toFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  interface{}
+		want    Filter
+		wantErr bool
+	}{
+		{
+			name:   "success when filter is Filter",
+			filter: Filter{V0: []string{"alice"}},
+			want:   Filter{V0: []string{"alice"}},
+		},
+		{
+			name:   "success when filter is *Filter",
+			filter: &Filter{V0: []string{"alice"}},
+			want:   Filter{V0: []string{"alice"}},
+		},
+		{
+			name:   "success when filter is nil *Filter",
+			filter: (*Filter)(nil),
+			want:   Filter{},
+		},
+		{
+			name:    "fail when filter is unsupported type",
+			filter:  "alice",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toFilter(tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("toFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("toFilter() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package casbinbunadapter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+// historyTable is the model to create the history table, whose value columns are created like the policy table.
+// Each row is a period during which the policy rule was stored, which is still open while valid_to is NULL.
+type historyTable struct {
+	bun.BaseModel `bun:"casbin_policies_history"`
+	ID            int64  `bun:"id,pk,autoincrement"`
+	PType         string `bun:"ptype,type:varchar(100),notnull"`
+}
+
+// WithHistory makes the adapter keep the history of the policy rules in the history table,
+// in the same transaction as the write, so that the policy at a past time can be loaded with LoadPolicyAt
+// and restored with RestoreTo.
+func WithHistory() adapterOption {
+	return func(a *bunAdapter) {
+		a.history = true
+	}
+}
+
+func (a *bunAdapter) historyTableName() string {
+	return a.tableName + "_history"
+}
+
+// createHistoryTable creates the history table unless it exists.
+func (a *bunAdapter) createHistoryTable(ctx context.Context) error {
+	return a.createTableIfNotExists(ctx, a.createHistoryTableQuery(), a.historyTableName())
+}
+
+// startHistory starts the empty history table with an empty period, which marks when the history began,
+// followed by the periods of the policies stored at the moment, so that the history of an empty table is an empty policy.
+// The time is taken from the clock of the adapter like recordHistory, rather than from the database.
+func (a *bunAdapter) startHistory(ctx context.Context) error {
+	return a.runInTx(ctx, func(ctx context.Context, tx bun.Tx) error {
+		count, err := tx.NewSelect().TableExpr("?", bun.Ident(a.historyTableName())).Count(ctx)
+		if err != nil || count > 0 {
+			return err
+		}
+
+		now := time.Now().UTC()
+		if _, err := tx.NewRaw(
+			"INSERT INTO ? (ptype, valid_from, valid_to) VALUES (?, ?, ?)",
+			bun.Ident(a.historyTableName()), "", now, now,
+		).Exec(ctx); err != nil {
+			return err
+		}
+		columns := bun.In(a.ruleColumnIdents())
+		_, err = tx.NewRaw(
+			"INSERT INTO ? (?, valid_from) SELECT ?, ? FROM ?",
+			bun.Ident(a.historyTableName()), columns, columns, now, bun.Ident(a.tableName),
+		).Exec(ctx)
+		return err
+	})
+}
+
+func (a *bunAdapter) createHistoryTableQuery() *bun.CreateTableQuery {
+	query := a.db.NewCreateTable().
+		Model((*historyTable)(nil)).
+		ModelTableExpr("?", bun.Ident(a.historyTableName())).
+		IfNotExists()
+	for _, column := range a.valueColumns() {
+		query = query.ColumnExpr("? ?", bun.Ident(column), bun.Safe(a.columnType))
+	}
+	return query.
+		ColumnExpr("? ?", bun.Ident(ruleWidthColumn), bun.Safe(ruleWidthType)).
+		ColumnExpr("valid_from ? NOT NULL", bun.Safe(a.timestampType())).
+		ColumnExpr("valid_to ?", bun.Safe(a.timestampType()))
+}
+
+// timestampType returns the type of the columns of the time, whose precision tells apart the writes made in the same second.
+func (a *bunAdapter) timestampType() string {
+	switch a.db.Dialect().Name() {
+	case dialect.MySQL:
+		return "datetime(6)"
+	case dialect.MSSQL:
+		return "datetime2"
+	case dialect.PG:
+		return "timestamptz"
+	default:
+		return "timestamp"
+	}
+}
+
+// recordHistory closes the periods of the policies removed by the change and opens the periods of the policies added,
+// with db, which is the transaction of the write.
+func (a *bunAdapter) recordHistory(ctx context.Context, db bun.IDB, change *policyChange) error {
+	removed, added := a.changedPolicies(change)
+	now := time.Now().UTC()
+
+	for _, policy := range removed {
+		if _, err := db.NewUpdate().
+			ModelTableExpr("?", bun.Ident(a.historyTableName())).
+			Set("valid_to = ?", now).
+			Where("valid_to IS NULL").
+			ApplyQueryBuilder(a.policyFilter(policy)).
+			Exec(ctx); err != nil {
+			return wrapError(err)
+		}
+	}
+
+	for _, batch := range chunk(added, a.batchSizeOf(db)) {
+		rows := make([][]interface{}, 0, len(batch))
+		for _, policy := range batch {
+			rows = append(rows, append(a.ruleRow(policy), now))
+		}
+		if _, err := db.NewRaw(
+			"INSERT INTO ? (?, valid_from) VALUES ?",
+			bun.Ident(a.historyTableName()), bun.In(a.ruleColumnIdents()), bun.In(rows),
+		).Exec(ctx); err != nil {
+			return wrapError(err)
+		}
+	}
+	return nil
+}
+
+// changedPolicies returns the policies removed and added by the change.
+func (a *bunAdapter) changedPolicies(change *policyChange) (removed, added []CasbinPolicy) {
+	newPolicies := func(rules [][]string) []CasbinPolicy {
+		policies := make([]CasbinPolicy, 0, len(rules))
+		for _, rule := range rules {
+			policies = append(policies, newCasbinPolicy(change.ptype, rule))
+		}
+		return policies
+	}
+
+	switch change.method {
+	case UpdateForAddPolicy, UpdateForAddPolicies:
+		return nil, newPolicies(change.rules)
+	case UpdateForRemovePolicy, UpdateForRemovePolicies:
+		return newPolicies(change.rules), nil
+	case UpdateForUpdatePolicy, UpdateForUpdatePolicies:
+		return newPolicies(change.rules), newPolicies(change.newRules)
+	default:
+		return change.removed, change.added
+	}
+}
+
+// LoadPolicyAt loads the policy rules stored at the time into the model, which is rebuilt from the history table.
+// It returns ErrHistoryNotFound without WithHistory, when the history table has been cleared, or when the time is before the history began.
+func (a *bunAdapter) LoadPolicyAt(model model.Model, at time.Time) error {
+	return a.loadPolicyAt(context.Background(), model, at)
+}
+
+func (a *bunAdapter) loadPolicyAt(ctx context.Context, model model.Model, at time.Time) error {
+	policies, err := a.policiesAt(ctx, a.db, at)
+	if err != nil {
+		return err
+	}
+
+	loader := newPolicyLoader(model)
+	for _, policy := range policies {
+		if err := loader.add(policy); err != nil {
+			return err
+		}
+	}
+	return loader.flush()
+}
+
+// RestoreTo rewrites the stored policy rules to the ones stored at the time in a transaction,
+// only writing the difference like WithIncrementalSave. The restore is recorded as SavePolicy,
+// so the watchers reload the whole policy and the history keeps the policy before the restore.
+// It returns ErrHistoryNotFound without WithHistory, when the history table has been cleared, or when the time is before the history began.
+func (a *bunAdapter) RestoreTo(at time.Time) error {
+	return a.restoreTo(context.Background(), at)
+}
+
+func (a *bunAdapter) restoreTo(ctx context.Context, at time.Time) error {
+	if a.isFiltered.Load() {
+		return ErrFilteredPolicy
+	}
+
+	change := policyChange{method: UpdateForSavePolicy}
+	return a.writeInTx(ctx, &change, func(ctx context.Context, tx bun.Tx) error {
+		policies, err := a.policiesAt(ctx, tx, at)
+		if err != nil {
+			return err
+		}
+		_, err = a.applyDiff(ctx, tx, &change, policies)
+		return err
+	})
+}
+
+// policiesAt returns the policies stored at the time, in the order they were added.
+// The policies stored twice, which WithIgnoreDuplicates may leave in the history, are returned once.
+func (a *bunAdapter) policiesAt(ctx context.Context, db bun.IDB, at time.Time) ([]CasbinPolicy, error) {
+	if !a.history {
+		return nil, fmt.Errorf("%w: the adapter is created without WithHistory", ErrHistoryNotFound)
+	}
+	at = at.UTC()
+
+	// the time is checked against the first period, which is the empty period marking the start of the history,
+	// since the history before it is unknown
+	var start time.Time
+	err := db.NewSelect().
+		TableExpr("?", bun.Ident(a.historyTableName())).
+		Column("valid_from").
+		OrderExpr("? ASC", bun.Ident("id")).
+		Limit(1).
+		Scan(ctx, &start)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// a history cleared without its start is never taken as an empty policy, which would remove all the stored policies on RestoreTo
+		return nil, fmt.Errorf("%w: the history table is empty", ErrHistoryNotFound)
+	case err != nil:
+		return nil, wrapError(err)
+	case at.Before(start):
+		return nil, fmt.Errorf("%w: %v is before the history began at %v", ErrHistoryNotFound, at, start)
+	}
+
+	rows, err := db.NewSelect().
+		TableExpr("?", bun.Ident(a.historyTableName())).
+		Column("id").
+		Column(a.ruleColumns()...).
+		Where("valid_from <= ?", at).
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("valid_to IS NULL").WhereOr("valid_to > ?", at)
+		}).
+		OrderExpr("? ASC", bun.Ident("id")).
+		Rows(ctx)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	policies := make([]CasbinPolicy, 0)
+	seen := make(map[string]bool)
+	err = a.scanRows(rows, func(policy CasbinPolicy) error {
+		key := a.policyKey(policy)
+		if !seen[key] {
+			seen[key] = true
+			policies = append(policies, policy)
+		}
+		return nil
+	})
+	return policies, err
+}
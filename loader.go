@@ -0,0 +1,94 @@
+package casbinbunadapter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/casbin/casbin/v2/model"
+)
+
+// loaderBatchSize is the number of the rules of a ptype buffered before they are added to the model.
+const loaderBatchSize = 1000
+
+// policyLoader adds the scanned policies to the model in bulk per ptype.
+// Unlike model.HasPolicyEx and model.AddPolicy, it looks up the assertion once per ptype
+// and appends the rules without keeping them sorted by priority, which flush sorts once all of them are added.
+type policyLoader struct {
+	model   model.Model
+	pending map[string][]CasbinPolicy
+}
+
+func newPolicyLoader(model model.Model) *policyLoader {
+	return &policyLoader{
+		model:   model,
+		pending: make(map[string][]CasbinPolicy),
+	}
+}
+
+// add buffers the rule of the policy, and adds the buffered rules of its ptype to the model when the buffer is full.
+func (l *policyLoader) add(policy CasbinPolicy) error {
+	pending := append(l.pending[policy.PType], policy)
+	l.pending[policy.PType] = pending
+	if len(pending) < loaderBatchSize {
+		return nil
+	}
+	return l.flushPType(policy.PType)
+}
+
+// flush adds all the buffered rules to the model, and sorts them by priority like the enforcer does after loading them,
+// so that the model passed to LoadPolicyAt without an enforcer is sorted as well. The sort rebuilds PolicyMap.
+func (l *policyLoader) flush() error {
+	for ptype := range l.pending {
+		if err := l.flushPType(ptype); err != nil {
+			return err
+		}
+	}
+	if err := l.model.SortPoliciesBySubjectHierarchy(); err != nil {
+		return err
+	}
+	return l.model.SortPoliciesByPriority()
+}
+
+func (l *policyLoader) flushPType(ptype string) error {
+	policies := l.pending[ptype]
+	if len(policies) == 0 {
+		return nil
+	}
+	l.pending[ptype] = policies[:0]
+
+	sec := ptype[:1]
+	ast, err := l.model.GetAssertion(sec, ptype)
+	if err != nil {
+		return err
+	}
+	for _, policy := range policies {
+		rule := policy.filterValues()
+		// restore the trailing empty values of the rows stored without their width,
+		// which are indistinguishable from the unused columns
+		if policy.Width == 0 {
+			for len(rule) < len(ast.Tokens) {
+				rule = append(rule, "")
+			}
+		}
+		if err := validateRuleSize(sec, ast.Tokens, rule); err != nil {
+			return err
+		}
+
+		// skip the duplicates, which may be stored when the unique index could not be created
+		key := strings.Join(rule, model.DefaultSep)
+		if _, ok := ast.PolicyMap[key]; ok {
+			continue
+		}
+		ast.Policy = append(ast.Policy, rule)
+		ast.PolicyMap[key] = len(ast.Policy) - 1
+	}
+	return nil
+}
+
+// validateRuleSize checks the number of the values of the rule in the same way as model.HasPolicyEx.
+func validateRuleSize(sec string, tokens []string, rule []string) error {
+	if (sec == "p" && len(rule) != len(tokens)) || (sec == "g" && len(rule) < len(tokens)) {
+		return fmt.Errorf("invalid policy rule size: expected %d, got %d, rule: %v", len(tokens), len(rule), rule)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package casbinbunadapter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestModel(tb testing.TB) model.Model {
+	m, err := model.NewModelFromFile("testdata/rbac_model.conf")
+	if err != nil {
+		tb.Fatalf("failed to create model: %v", err)
+	}
+	return m
+}
+
+func TestPolicyLoader(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []CasbinPolicy
+		want     [][]string
+		wantErr  bool
+	}{
+		{
+			name: "duplicates are skipped",
+			policies: []CasbinPolicy{
+				newCasbinPolicy("p", []string{"alice", "data1", "read"}),
+				newCasbinPolicy("p", []string{"alice", "data1", "read"}),
+				newCasbinPolicy("p", []string{"bob", "data2", "write"}),
+			},
+			want: [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}},
+		},
+		{
+			name: "trailing empty values are restored",
+			policies: []CasbinPolicy{
+				newCasbinPolicy("p", []string{"alice", "", ""}),
+			},
+			want: [][]string{{"alice", "", ""}},
+		},
+		{
+			name: "trailing empty values of the rows stored without their width are restored",
+			policies: []CasbinPolicy{
+				{PType: "p", V0: "alice"},
+			},
+			want: [][]string{{"alice", "", ""}},
+		},
+		{
+			name: "fail when the rule is shorter than the policy definition",
+			policies: []CasbinPolicy{
+				newCasbinPolicy("p", []string{"alice", "data1"}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail when the rule is longer than the policy definition",
+			policies: []CasbinPolicy{
+				newCasbinPolicy("p", []string{"alice", "data1", "read", "allow"}),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(t)
+			l := newPolicyLoader(m)
+			for _, policy := range tt.policies {
+				if err := l.add(policy); err != nil {
+					t.Fatalf("add() error = %v", err)
+				}
+			}
+			err := l.flush()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("flush() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.want, m["p"]["p"].Policy); diff != "" {
+				t.Errorf("policy mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPolicyLoader_priority(t *testing.T) {
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = priority, sub, obj, act, eft
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = priority(p_eft) || deny
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`)
+	if err != nil {
+		t.Fatalf("failed to create model: %v", err)
+	}
+
+	l := newPolicyLoader(m)
+	for _, rule := range [][]string{
+		{"10", "data1_deny_group", "data1", "read", "deny"},
+		{"1", "alice", "data1", "read", "allow"},
+		{"5", "bob", "data2", "write", "allow"},
+	} {
+		if err := l.add(newCasbinPolicy("p", rule)); err != nil {
+			t.Fatalf("add() error = %v", err)
+		}
+	}
+	if err := l.flush(); err != nil {
+		t.Fatalf("flush() error = %v", err)
+	}
+
+	// the rules are sorted by priority, and PolicyMap follows their positions
+	want := [][]string{
+		{"1", "alice", "data1", "read", "allow"},
+		{"5", "bob", "data2", "write", "allow"},
+		{"10", "data1_deny_group", "data1", "read", "deny"},
+	}
+	ast := m["p"]["p"]
+	if diff := cmp.Diff(want, ast.Policy); diff != "" {
+		t.Errorf("policy mismatch (-want +got):\n%s", diff)
+	}
+	for i, rule := range ast.Policy {
+		if got := ast.PolicyMap[strings.Join(rule, model.DefaultSep)]; got != i {
+			t.Errorf("PolicyMap[%v] = %d, want %d", rule, got, i)
+		}
+	}
+}
+
+// BenchmarkLoadPolicy compares adding the scanned policies to the model one by one
+// with model.HasPolicyEx and model.AddPolicy, and in bulk with policyLoader.
+func BenchmarkLoadPolicy(b *testing.B) {
+	policies := make([]CasbinPolicy, 0, 100000)
+	for i := 0; i < cap(policies); i++ {
+		policies = append(policies, newCasbinPolicy("p", []string{fmt.Sprintf("user%d", i), fmt.Sprintf("data%d", i%100), "read"}))
+	}
+
+	b.Run("HasPolicyEx", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m := newTestModel(b)
+			for _, policy := range policies {
+				rule := policy.filterValues()
+				ok, err := m.HasPolicyEx("p", policy.PType, rule)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if !ok {
+					_ = m.AddPolicy("p", policy.PType, rule)
+				}
+			}
+		}
+	})
+
+	b.Run("policyLoader", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m := newTestModel(b)
+			l := newPolicyLoader(m)
+			for _, policy := range policies {
+				if err := l.add(policy); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := l.flush(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package casbinbunadapter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+// migrationLockTimeout is how long Migrate waits for another adapter migrating the same table on mysql and mssql.
+const migrationLockTimeout = time.Minute
+
+// schemaMigration is the row of the table which records the applied migrations.
+type schemaMigration struct {
+	bun.BaseModel `bun:"casbin_policies_migrations"`
+	Version       int       `bun:"version,pk"`
+	Name          string    `bun:"name,type:varchar(100),notnull"`
+	AppliedAt     time.Time `bun:"applied_at,notnull"`
+}
+
+// migration upgrades the schema of the policy table to its version.
+// up must be idempotent, since the table may have been created before the migrations were recorded.
+// The migrations of several adapters starting at once are serialized by withMigrationLock.
+type migration struct {
+	version int
+	name    string
+	up      func(ctx context.Context, a *bunAdapter) error
+}
+
+// migrations are the migrations in the order of their versions.
+// A new migration must be appended with the next version, and the existing ones must never be changed.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create table",
+		up: func(ctx context.Context, a *bunAdapter) error {
+			return a.createTable(ctx)
+		},
+	},
+	{
+		version: 2,
+		name:    "create unique index",
+		up: func(ctx context.Context, a *bunAdapter) error {
+			return a.createUniqueIndex(ctx, false)
+		},
+	},
+	{
+		version: 3,
+		name:    "add rule width column",
+		up: func(ctx context.Context, a *bunAdapter) error {
+			return a.addRuleWidthColumn(ctx, a.tableName)
+		},
+	},
+}
+
+// latestSchemaVersion returns the version of the last migration.
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrationTableName returns the name of the table which records the applied migrations.
+func (a *bunAdapter) migrationTableName() string {
+	return fmt.Sprintf("%s_migrations", a.tableName)
+}
+
+// Migrate applies the migrations which have not been applied to the policy table yet, in the order of their versions.
+// It is called by the constructors, and is exposed for the tools which migrate the table in advance.
+// The adapters migrating the same table at once wait for each other, see withMigrationLock.
+func (a *bunAdapter) Migrate(ctx context.Context) error {
+	return a.withMigrationLock(ctx, a.migrate)
+}
+
+func (a *bunAdapter) migrate(ctx context.Context) error {
+	query := a.db.NewCreateTable().
+		Model((*schemaMigration)(nil)).
+		ModelTableExpr("?", bun.Ident(a.migrationTableName())).
+		IfNotExists()
+	if err := a.createTableIfNotExists(ctx, query, a.migrationTableName()); err != nil {
+		return err
+	}
+
+	current, err := a.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := m.up(ctx, a); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.name, err)
+		}
+		// another adapter recording the same migration at once is not an error
+		record := &schemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now().UTC()}
+		if _, err := a.db.NewInsert().
+			Model(record).
+			ModelTableExpr("?", bun.Ident(a.migrationTableName())).
+			Exec(ctx); err != nil && !isUniqueViolation(err) {
+			return wrapError(err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the last migration applied to the policy table,
+// which is zero when no migration has been applied.
+func (a *bunAdapter) SchemaVersion(ctx context.Context) (int, error) {
+	exists, err := a.tableExists(ctx, a.migrationTableName())
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+	if err := a.db.NewSelect().
+		ModelTableExpr("?", bun.Ident(a.migrationTableName())).
+		ColumnExpr("COALESCE(MAX(?), 0)", bun.Ident("version")).
+		Scan(ctx, &version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// withMigrationLock runs fn holding the lock of the database on the migrations of the policy table,
+// which is pg_advisory_lock on postgres, GET_LOCK on mysql and sp_getapplock on mssql.
+// The lock is held by a connection of its own for the session, so fn needs another connection of the pool,
+// and the lock is skipped when the pool is limited to a single connection.
+// sqlite serializes the writes of the whole database, and createUniqueIndex tolerates the index created meanwhile.
+func (a *bunAdapter) withMigrationLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	var lock, unlock string
+	var args []interface{}
+	switch a.db.Dialect().Name() {
+	case dialect.PG:
+		lock = "SELECT 1 FROM (SELECT pg_advisory_lock(?)) AS l"
+		unlock = "SELECT pg_advisory_unlock(?)"
+		args = []interface{}{a.migrationLockKey()}
+	case dialect.MySQL:
+		lock = "SELECT GET_LOCK(?, ?)"
+		unlock = "SELECT RELEASE_LOCK(?)"
+		args = []interface{}{a.migrationLockName(), int(migrationLockTimeout / time.Second)}
+	case dialect.MSSQL:
+		lock = "DECLARE @status int; EXEC @status = sp_getapplock @Resource = ?, @LockMode = 'Exclusive', " +
+			"@LockOwner = 'Session', @LockTimeout = ?; SELECT CASE WHEN @status >= 0 THEN 1 ELSE 0 END"
+		unlock = "EXEC sp_releaseapplock @Resource = ?, @LockOwner = 'Session'"
+		args = []interface{}{a.migrationLockName(), int(migrationLockTimeout / time.Millisecond)}
+	default:
+		return fn(ctx)
+	}
+	if a.db.Stats().MaxOpenConnections == 1 {
+		// the only connection of the pool cannot hold the lock while fn runs
+		return fn(ctx)
+	}
+
+	conn, err := a.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var acquired sql.NullInt64
+	if err := conn.NewRaw(lock, args...).Scan(ctx, &acquired); err != nil {
+		return fmt.Errorf("failed to lock the migrations of %s: %w", a.tableName, err)
+	}
+	if acquired.Int64 != 1 {
+		return fmt.Errorf("failed to lock the migrations of %s: timed out after %v", a.tableName, migrationLockTimeout)
+	}
+
+	err = fn(ctx)
+	// the lock is released even when ctx is done, otherwise the connection returns to the pool holding it
+	if _, unlockErr := conn.ExecContext(context.WithoutCancel(ctx), unlock, args[0]); unlockErr != nil && err == nil {
+		err = fmt.Errorf("failed to unlock the migrations of %s: %w", a.tableName, unlockErr)
+	}
+	return err
+}
+
+// migrationLockName returns the name of the lock on the migrations of the policy table.
+// It is hashed to fit in the 64 characters of the lock names on mysql.
+func (a *bunAdapter) migrationLockName() string {
+	return fmt.Sprintf("casbin_migrations_%x", uint64(a.migrationLockKey()))
+}
+
+// migrationLockKey returns the key of the advisory lock on the migrations of the policy table on postgres.
+func (a *bunAdapter) migrationLockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(a.migrationTableName()))
+	return int64(h.Sum64())
+}
@@ -1,6 +1,13 @@
 package casbinbunadapter
 
-import "github.com/uptrace/bun"
+import (
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+// defaultFieldCount is the number of the value columns defined in CasbinPolicy.
+const defaultFieldCount = 6
 
 // Database storage format following the below
 // https://casbin.org/docs/policy-storage#database-storage-format
@@ -14,85 +21,40 @@ type CasbinPolicy struct {
 	V3            string `bun:"v3,type:varchar(100)"`
 	V4            string `bun:"v4,type:varchar(100)"`
 	V5            string `bun:"v5,type:varchar(100)"`
+	// Extra holds the values from v6, which are stored in the columns added by WithFieldCount.
+	Extra []string `bun:"-"`
+	// Width is the number of the values of the rule, including the trailing empty ones,
+	// which is zero for the rows stored before the width was recorded.
+	Width int `bun:"-"`
 }
 
-func (c CasbinPolicy) toSlice() []string {
-	policies := make([]string, 0)
-	if c.PType != "" {
-		policies = append(policies, c.PType)
-	}
-	if c.V0 != "" {
-		policies = append(policies, c.V0)
-	}
-	if c.V1 != "" {
-		policies = append(policies, c.V1)
-	}
-	if c.V2 != "" {
-		policies = append(policies, c.V2)
-	}
-	if c.V3 != "" {
-		policies = append(policies, c.V3)
-	}
-	if c.V4 != "" {
-		policies = append(policies, c.V4)
-	}
-	if c.V5 != "" {
-		policies = append(policies, c.V5)
-	}
-	return policies
+func valueColumn(i int) string {
+	return fmt.Sprintf("v%d", i)
 }
 
-func (c CasbinPolicy) filterValues() []string {
-	values := make([]string, 0)
-	if c.V0 != "" {
-		values = append(values, c.V0)
-	}
-	if c.V1 != "" {
-		values = append(values, c.V1)
-	}
-	if c.V2 != "" {
-		values = append(values, c.V2)
-	}
-	if c.V3 != "" {
-		values = append(values, c.V3)
-	}
-	if c.V4 != "" {
-		values = append(values, c.V4)
-	}
-	if c.V5 != "" {
-		values = append(values, c.V5)
-	}
-
-	return values
+// toSlice returns the ptype followed by the rule of the policy.
+func (c CasbinPolicy) toSlice() []string {
+	return append([]string{c.PType}, c.filterValues()...)
 }
 
-func (c CasbinPolicy) filterValuesWithKey() map[string]string {
-	values := make(map[string]string)
-	if c.V0 != "" {
-		values["v0"] = c.V0
-	}
-	if c.V1 != "" {
-		values["v1"] = c.V1
-	}
-	if c.V2 != "" {
-		values["v2"] = c.V2
-	}
-	if c.V3 != "" {
-		values["v3"] = c.V3
-	}
-	if c.V4 != "" {
-		values["v4"] = c.V4
+// filterValues returns the rule of the policy.
+// The values keep their positions, and the rule is cut at its width, so that the trailing empty values are kept.
+// The rows stored before the width was recorded lose their trailing empty values, which cannot be told from the unused columns.
+func (c CasbinPolicy) filterValues() []string {
+	values := c.values(c.Width)
+	if c.Width > 0 {
+		return values[:c.Width]
 	}
-	if c.V5 != "" {
-		values["v5"] = c.V5
+	for len(values) > 0 && values[len(values)-1] == "" {
+		values = values[:len(values)-1]
 	}
-
 	return values
 }
 
 func newCasbinPolicy(ptype string, rule []string) CasbinPolicy {
 	c := CasbinPolicy{
 		PType: ptype,
+		Width: len(rule),
 	}
 
 	for i, v := range rule {
@@ -109,8 +71,20 @@ func newCasbinPolicy(ptype string, rule []string) CasbinPolicy {
 			c.V4 = v
 		case 5:
 			c.V5 = v
+		default:
+			c.Extra = append(c.Extra, v)
 		}
 	}
 
 	return c
 }
+
+// values returns all the values of the policy by their positions,
+// padded with empty strings up to fieldCount.
+func (c CasbinPolicy) values(fieldCount int) []string {
+	values := append([]string{c.V0, c.V1, c.V2, c.V3, c.V4, c.V5}, c.Extra...)
+	for len(values) < fieldCount {
+		values = append(values, "")
+	}
+	return values
+}
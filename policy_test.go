@@ -25,6 +25,7 @@ func Test_newCasbinPolicy(t *testing.T) {
 			want: CasbinPolicy{
 				PType: "p",
 				V0:    "alice",
+				Width: 1,
 			},
 		},
 		{
@@ -37,6 +38,7 @@ func Test_newCasbinPolicy(t *testing.T) {
 				PType: "p",
 				V0:    "alice",
 				V1:    "data1",
+				Width: 2,
 			},
 		},
 		{
@@ -50,6 +52,7 @@ func Test_newCasbinPolicy(t *testing.T) {
 				V0:    "alice",
 				V1:    "data1",
 				V2:    "read",
+				Width: 3,
 			},
 		},
 		{
@@ -64,6 +67,7 @@ func Test_newCasbinPolicy(t *testing.T) {
 				V1:    "data1",
 				V2:    "read",
 				V3:    "allow",
+				Width: 4,
 			},
 		},
 		{
@@ -79,6 +83,7 @@ func Test_newCasbinPolicy(t *testing.T) {
 				V2:    "read",
 				V3:    "allow",
 				V4:    "1",
+				Width: 5,
 			},
 		},
 		{
@@ -95,6 +100,25 @@ func Test_newCasbinPolicy(t *testing.T) {
 				V3:    "allow",
 				V4:    "1",
 				V5:    "2",
+				Width: 6,
+			},
+		},
+		{
+			name: "success when ptype is p and eight rules are provided",
+			args: args{
+				ptype: "p",
+				rule:  []string{"alice", "data1", "read", "allow", "1", "2", "3", "4"},
+			},
+			want: CasbinPolicy{
+				PType: "p",
+				V0:    "alice",
+				V1:    "data1",
+				V2:    "read",
+				V3:    "allow",
+				V4:    "1",
+				V5:    "2",
+				Extra: []string{"3", "4"},
+				Width: 8,
 			},
 		},
 	}
@@ -186,6 +210,15 @@ func TestCasbinPolicy_toSlice(t *testing.T) {
 			},
 			want: []string{"p", "alice", "data1", "read", "allow", "1", "2"},
 		},
+		{
+			name: "empty values in the middle keep their positions",
+			fields: fields{
+				ptype: "p",
+				v0:    "alice",
+				v2:    "read",
+			},
+			want: []string{"p", "alice", "", "read"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -207,12 +240,13 @@ func TestCasbinPolicy_toSlice(t *testing.T) {
 
 func TestCasbinPolicy_filterValues(t *testing.T) {
 	type fields struct {
-		v0 string
-		v1 string
-		v2 string
-		v3 string
-		v4 string
-		v5 string
+		v0    string
+		v1    string
+		v2    string
+		v3    string
+		v4    string
+		v5    string
+		width int
 	}
 	tests := []struct {
 		name   string
@@ -276,16 +310,34 @@ func TestCasbinPolicy_filterValues(t *testing.T) {
 			},
 			want: []string{"alice", "data1", "read", "allow", "1", "2"},
 		},
+		{
+			name: "empty values in the middle keep their positions",
+			fields: fields{
+				v0: "alice",
+				v2: "read",
+			},
+			want: []string{"alice", "", "read"},
+		},
+		{
+			name: "trailing empty values are kept up to the width",
+			fields: fields{
+				v0:    "bob",
+				v1:    "data2",
+				width: 3,
+			},
+			want: []string{"bob", "data2", ""},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			policy := CasbinPolicy{
-				V0: tt.fields.v0,
-				V1: tt.fields.v1,
-				V2: tt.fields.v2,
-				V3: tt.fields.v3,
-				V4: tt.fields.v4,
-				V5: tt.fields.v5,
+				V0:    tt.fields.v0,
+				V1:    tt.fields.v1,
+				V2:    tt.fields.v2,
+				V3:    tt.fields.v3,
+				V4:    tt.fields.v4,
+				V5:    tt.fields.v5,
+				Width: tt.fields.width,
 			}
 			if diff := cmp.Diff(tt.want, policy.filterValues()); diff != "" {
 				t.Errorf("filterValues() mismatch (-want +got):\n%s", diff)
@@ -294,90 +346,30 @@ func TestCasbinPolicy_filterValues(t *testing.T) {
 	}
 }
 
-func TestCasbinPolicy_filterValuesWithKey(t *testing.T) {
-	type fields struct {
-		v0 string
-		v1 string
-		v2 string
-		v3 string
-		v4 string
-		v5 string
-	}
+func TestCasbinPolicy_values(t *testing.T) {
 	tests := []struct {
-		name   string
-		fields fields
-		want   map[string]string
+		name       string
+		policy     CasbinPolicy
+		fieldCount int
+		want       []string
 	}{
 		{
-			name: "success when one rules is provided",
-			fields: fields{
-				v0: "alice",
-			},
-			want: map[string]string{"v0": "alice"},
-		},
-		{
-			name: "success when two rules are provided",
-			fields: fields{
-				v0: "alice",
-				v1: "data1",
-			},
-			want: map[string]string{"v0": "alice", "v1": "data1"},
-		},
-		{
-			name: "success when three rules are provided",
-			fields: fields{
-				v0: "alice",
-				v1: "data1",
-				v2: "read",
-			},
-			want: map[string]string{"v0": "alice", "v1": "data1", "v2": "read"},
-		},
-		{
-			name: "success when four rules are provided",
-			fields: fields{
-				v0: "alice",
-				v1: "data1",
-				v2: "read",
-				v3: "allow",
-			},
-			want: map[string]string{"v0": "alice", "v1": "data1", "v2": "read", "v3": "allow"},
-		},
-		{
-			name: "success when five rules are provided",
-			fields: fields{
-				v0: "alice",
-				v1: "data1",
-				v2: "read",
-				v3: "allow",
-				v4: "1",
-			},
-			want: map[string]string{"v0": "alice", "v1": "data1", "v2": "read", "v3": "allow", "v4": "1"},
+			name:       "padded with empty strings up to field count",
+			policy:     CasbinPolicy{PType: "p", V0: "alice", V1: "data1"},
+			fieldCount: 6,
+			want:       []string{"alice", "data1", "", "", "", ""},
 		},
 		{
-			name: "success when six rules are provided",
-			fields: fields{
-				v0: "alice",
-				v1: "data1",
-				v2: "read",
-				v3: "allow",
-				v4: "1",
-				v5: "2",
-			},
-			want: map[string]string{"v0": "alice", "v1": "data1", "v2": "read", "v3": "allow", "v4": "1", "v5": "2"},
+			name:       "extra values follow v5",
+			policy:     CasbinPolicy{PType: "p", V0: "alice", V5: "2", Extra: []string{"3"}},
+			fieldCount: 8,
+			want:       []string{"alice", "", "", "", "", "2", "3", ""},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			policy := CasbinPolicy{
-				V0: tt.fields.v0,
-				V1: tt.fields.v1,
-				V2: tt.fields.v2,
-				V3: tt.fields.v3,
-				V4: tt.fields.v4,
-				V5: tt.fields.v5,
-			}
-			if diff := cmp.Diff(tt.want, policy.filterValuesWithKey()); diff != "" {
-				t.Errorf("filterValuesWithKey() mismatch (-want +got):\n%s", diff)
+			if diff := cmp.Diff(tt.want, tt.policy.values(tt.fieldCount)); diff != "" {
+				t.Errorf("values() mismatch (-want +got):\n%s", diff)
 			}
 		})
 	}
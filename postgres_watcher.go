@@ -0,0 +1,257 @@
+package casbinbunadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+var (
+	// check if the PostgresWatcher implements the WatcherEx interface
+	_ persist.WatcherEx = (*PostgresWatcher)(nil)
+	// check if the PostgresWatcher implements the UpdatableWatcher interface
+	_ persist.UpdatableWatcher = (*PostgresWatcher)(nil)
+)
+
+const (
+	// maxNotifyPayload is the maximum length in bytes of the payload of NOTIFY.
+	maxNotifyPayload = 8000
+	// pingInterval is the interval of checking the connection of the listener while no notification arrives.
+	pingInterval = 30 * time.Second
+	// reconnectDelay is the delay before the listener reconnects after the connection has failed.
+	reconnectDelay = time.Second
+)
+
+// PayloadFormat encodes the changes into the payloads of the notifications, and decodes the payloads.
+type PayloadFormat interface {
+	Encode(msg WatcherMessage) (string, error)
+	Decode(payload string) (WatcherMessage, error)
+}
+
+// jsonPayload is the PayloadFormat by default, which encodes the WatcherMessage as JSON.
+type jsonPayload struct{}
+
+func (jsonPayload) Encode(msg WatcherMessage) (string, error) {
+	b, err := json.Marshal(msg)
+	return string(b), err
+}
+
+func (jsonPayload) Decode(payload string) (WatcherMessage, error) {
+	var msg WatcherMessage
+	err := json.Unmarshal([]byte(payload), &msg)
+	return msg, err
+}
+
+// PostgresWatcher is the persist.WatcherEx which notifies the other instances of the changes of the policy with LISTEN/NOTIFY.
+// The adapter sends NOTIFY in the same transaction as every write, so the notification is only delivered when the write is committed.
+// It requires the pgdriver driver, which NewAdapter uses for "postgres".
+type PostgresWatcher struct {
+	recordedUpdates
+
+	adapter  *bunAdapter
+	id       string
+	channel  string
+	format   PayloadFormat
+	listener *pgdriver.Listener
+
+	mu       sync.Mutex
+	callback func(string)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type postgresWatcherOption func(*PostgresWatcher)
+
+// WithChannel sets the channel of the notifications, which is the table name by default.
+func WithChannel(channel string) postgresWatcherOption {
+	return func(w *PostgresWatcher) {
+		if channel != "" {
+			w.channel = channel
+		}
+	}
+}
+
+// WithPayloadFormat sets the format of the payloads of the notifications, which is the WatcherMessage as JSON by default.
+// The update callback is called with the WatcherMessage as JSON regardless of the format.
+func WithPayloadFormat(format PayloadFormat) postgresWatcherOption {
+	return func(w *PostgresWatcher) {
+		if format != nil {
+			w.format = format
+		}
+	}
+}
+
+// NewPostgresWatcher creates the watcher of the adapter, and starts listening to the channel.
+// Only one watcher can be attached to an adapter, and the previous one stops sending the notifications.
+func NewPostgresWatcher(adapter persist.Adapter, opts ...postgresWatcherOption) (*PostgresWatcher, error) {
+	a, err := unwrapAdapter(adapter)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := a.db.Driver().(pgdriver.Driver); !ok {
+		return nil, fmt.Errorf("%w: PostgresWatcher requires the pgdriver driver, got %T", ErrUnsupportedDriver, a.db.Driver())
+	}
+
+	id, err := newInstanceID()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &PostgresWatcher{
+		adapter:  a,
+		id:       id,
+		channel:  a.tableName,
+		format:   jsonPayload{},
+		listener: pgdriver.NewListener(a.db),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	var ctx context.Context
+	ctx, w.cancel = context.WithCancel(context.Background())
+	if err := w.listener.Listen(ctx, w.channel); err != nil {
+		w.cancel()
+		_ = w.listener.Close()
+		return nil, err
+	}
+
+	a.setWatcher(w)
+
+	w.done = make(chan struct{})
+	go w.run(ctx)
+
+	return w, nil
+}
+
+// ID returns the ID of the watcher, which is set to WatcherMessage.ID of the changes made through its adapter.
+func (w *PostgresWatcher) ID() string {
+	return w.id
+}
+
+// run receives the notifications until the watcher is closed.
+// The listener reconnects after the connection has failed, and then the whole policy is reloaded,
+// since the notifications sent while it was disconnected are lost.
+func (w *PostgresWatcher) run(ctx context.Context) {
+	defer close(w.done)
+
+	connected := true
+	for {
+		_, payload, err := w.listener.ReceiveTimeout(ctx, pingInterval)
+		if ctx.Err() != nil {
+			return
+		}
+
+		var netErr net.Error
+		if err != nil && errors.As(err, &netErr) && netErr.Timeout() {
+			// no notification has arrived, so the connection is checked by listening again
+			err = w.listener.Listen(ctx, w.channel)
+			payload = ""
+		}
+		if err != nil {
+			if connected {
+				log.Printf("casbinbunadapter: the connection of the listener has failed: %v", err)
+			}
+			connected = false
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(reconnectDelay):
+			}
+			continue
+		}
+
+		if !connected {
+			connected = true
+			w.notify(WatcherMessage{Method: Update})
+		}
+		if payload != "" {
+			w.handle(payload)
+		}
+	}
+}
+
+// handle calls the update callback with the notification unless it has been sent by the watcher itself.
+func (w *PostgresWatcher) handle(payload string) {
+	msg, err := w.format.Decode(payload)
+	if err != nil {
+		log.Printf("casbinbunadapter: failed to decode the notification: %v", err)
+		return
+	}
+	if msg.ID == w.id {
+		return
+	}
+	w.notify(msg)
+}
+
+func (w *PostgresWatcher) notify(msg WatcherMessage) {
+	message, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("casbinbunadapter: failed to encode the watcher message: %v", err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.callback != nil {
+		w.callback(string(message))
+	}
+}
+
+// SetUpdateCallback sets the callback called with the WatcherMessage as JSON
+// when the policy has been changed by the other instances.
+// DefaultUpdateCallback applies the changes to a casbin.IDistributedEnforcer.
+func (w *PostgresWatcher) SetUpdateCallback(callback func(string)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callback = callback
+	return nil
+}
+
+// Update notifies the other instances that the policy has been changed, so that they reload the whole policy.
+func (w *PostgresWatcher) Update() error {
+	return w.recordChange(context.Background(), w.adapter.db, policyChange{method: Update})
+}
+
+// recordChange sends the notification of the change with db, which is the transaction of the write.
+func (w *PostgresWatcher) recordChange(ctx context.Context, db bun.IDB, change policyChange) error {
+	payload, err := w.payload(change)
+	if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "SELECT pg_notify(?, ?)", w.channel, payload); err != nil {
+		return wrapError(err)
+	}
+	return nil
+}
+
+// payload encodes the change into the payload of the notification.
+// The change which does not fit in the payload is sent as Update, so that the other instances reload the whole policy.
+func (w *PostgresWatcher) payload(change policyChange) (string, error) {
+	payload, err := w.format.Encode(change.message(w.id))
+	if err != nil {
+		return "", err
+	}
+	if len(payload) < maxNotifyPayload {
+		return payload, nil
+	}
+	return w.format.Encode(WatcherMessage{Method: Update, ID: w.id})
+}
+
+// Close stops listening to the channel, and stops sending the notifications of the adapter.
+func (w *PostgresWatcher) Close() {
+	w.adapter.unsetWatcher(w)
+	w.cancel()
+	_ = w.listener.Close()
+	<-w.done
+}
@@ -0,0 +1,80 @@
+package casbinbunadapter
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	defaultRetryBaseDelay = 10 * time.Millisecond
+	defaultRetryMaxDelay  = time.Second
+)
+
+// RetryPolicy configures the retry of the writes failing with a retryable error.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of the attempts including the first one.
+	MaxAttempts int
+	// Backoff returns the delay before the given retry, which starts from one.
+	// ExponentialBackoff with 10ms and 1s is used when it is nil.
+	Backoff func(retry int) time.Duration
+	// Retryable reports whether the error is retryable.
+	// IsRetryable is used when it is nil.
+	Retryable func(err error) bool
+}
+
+// ExponentialBackoff returns the backoff which doubles the delay from base on each retry, up to maxDelay.
+func ExponentialBackoff(base, maxDelay time.Duration) func(retry int) time.Duration {
+	return func(retry int) time.Duration {
+		delay := base
+		for i := 1; i < retry && delay < maxDelay; i++ {
+			delay *= 2
+		}
+		if delay > maxDelay {
+			return maxDelay
+		}
+		return delay
+	}
+}
+
+// IsRetryable reports whether the error is ErrTransient, which is classified from the native error codes
+// such as the deadlock of MySQL (1213), the serialization failure of PostgreSQL (40001) and SQLITE_BUSY,
+// or from driver.ErrBadConn failing a transaction.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrTransient)
+}
+
+// WithRetry makes the adapter retry the writes failing with a retryable error.
+// Each attempt runs the whole transaction or the single statement again, so no partial work is retried.
+// Loading policies is never retried, since the policies are added to the model while they are scanned.
+func WithRetry(policy RetryPolicy) adapterOption {
+	return func(a *bunAdapter) {
+		if policy.Backoff == nil {
+			policy.Backoff = ExponentialBackoff(defaultRetryBaseDelay, defaultRetryMaxDelay)
+		}
+		if policy.Retryable == nil {
+			policy.Retryable = IsRetryable
+		}
+		a.retryPolicy = policy
+	}
+}
+
+// retry runs fn until it succeeds, fails with an error which is not retryable,
+// or the attempts reach MaxAttempts. fn must be safe to run again after it fails,
+// which is the case for a transaction rolled back or a single statement.
+func (a *bunAdapter) retry(ctx context.Context, fn func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= a.retryPolicy.MaxAttempts || !a.retryPolicy.Retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(a.retryPolicy.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package casbinbunadapter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{retry: 1, want: 10 * time.Millisecond},
+		{retry: 2, want: 20 * time.Millisecond},
+		{retry: 3, want: 40 * time.Millisecond},
+		{retry: 4, want: 50 * time.Millisecond},
+		{retry: 10, want: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.retry); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.retry, got, tt.want)
+		}
+	}
+}
+
+func TestBunAdapter_retry(t *testing.T) {
+	deadlock := wrapError(&mysql.MySQLError{Number: 1213})
+	tests := []struct {
+		name         string
+		policy       *RetryPolicy
+		errs         []error
+		wantErr      error
+		wantAttempts int
+	}{
+		{
+			name:         "retried until it succeeds",
+			policy:       &RetryPolicy{MaxAttempts: 3},
+			errs:         []error{deadlock, deadlock, nil},
+			wantErr:      nil,
+			wantAttempts: 3,
+		},
+		{
+			name:         "not retried beyond the max attempts",
+			policy:       &RetryPolicy{MaxAttempts: 2},
+			errs:         []error{deadlock, deadlock, nil},
+			wantErr:      ErrTransient,
+			wantAttempts: 2,
+		},
+		{
+			name:         "not retried on an error which is not retryable",
+			policy:       &RetryPolicy{MaxAttempts: 3},
+			errs:         []error{ErrPolicyNotFound, nil},
+			wantErr:      ErrPolicyNotFound,
+			wantAttempts: 1,
+		},
+		{
+			name:         "not retried without WithRetry",
+			policy:       nil,
+			errs:         []error{deadlock, nil},
+			wantErr:      ErrTransient,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &bunAdapter{}
+			if tt.policy != nil {
+				tt.policy.Backoff = func(int) time.Duration { return 0 }
+				WithRetry(*tt.policy)(a)
+			}
+
+			attempts := 0
+			err := a.retry(context.Background(), func(ctx context.Context) error {
+				attempts++
+				return tt.errs[attempts-1]
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("retry() error = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,742 @@
+package casbinbunadapter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/dialect/feature"
+	"github.com/uptrace/bun/schema"
+)
+
+const (
+	// mysqlMaxKeyLength is the maximum length in bytes of an index key on InnoDB.
+	mysqlMaxKeyLength = 3072
+	// mysqlBytesPerChar is the maximum bytes per character of utf8mb4.
+	mysqlBytesPerChar = 4
+	// mssqlMaxKeyLength is the maximum length in bytes of a nonclustered index key.
+	mssqlMaxKeyLength = 1700
+	// pgMaxKeyLength is the maximum size in bytes of a btree index entry of postgres with the default page size.
+	pgMaxKeyLength = 2704
+	// pgIndexTupleHeader is the size in bytes of the header of a btree index entry of postgres.
+	pgIndexTupleHeader = 8
+	// ptypeColumnLength is the length of the ptype column.
+	ptypeColumnLength = 100
+	// defaultColumnLength is the length of the value columns by default.
+	defaultColumnLength = 100
+	// defaultColumnType is the type of the value columns by default.
+	defaultColumnType = "varchar(100)"
+	// ruleHashColumn is the column of the hash of the policy rule, which is uniquely indexed
+	// instead of the policy columns when they exceed the index key length limit.
+	ruleHashColumn = "rule_hash"
+	// ruleHashType is the type of the hex encoded SHA-256 in ruleHashColumn.
+	ruleHashType = "char(64)"
+	// ruleWidthColumn is the column of the number of the values of the rule,
+	// which tells the trailing empty values from the unused value columns.
+	ruleWidthColumn = "rule_width"
+	// ruleWidthType is the type of ruleWidthColumn.
+	ruleWidthType = "integer"
+)
+
+// charTypePattern matches the character types with a length, such as varchar(100) and nvarchar(255).
+var charTypePattern = regexp.MustCompile(`(?i)^\s*(n?)(?:var)?char\s*\(\s*(\d+)\s*\)\s*$`)
+
+// boundedTypePattern matches the types with a length which charTypePattern does not parse,
+// such as varchar(255) with a character set.
+var boundedTypePattern = regexp.MustCompile(`(?i)char\s*\(\s*\d+\s*\)`)
+
+// policyTable is the model of the table without the value columns, whose type is set by the options.
+type policyTable struct {
+	bun.BaseModel `bun:"casbin_policies"`
+	ID            int64  `bun:"id,pk,autoincrement"`
+	PType         string `bun:"ptype,type:varchar(100),notnull"`
+}
+
+// valueColumns returns the names of the value columns, which are v0 to v(fieldCount-1).
+func (a *bunAdapter) valueColumns() []string {
+	columns := make([]string, 0, a.fieldCount)
+	for i := 0; i < a.fieldCount; i++ {
+		columns = append(columns, valueColumn(i))
+	}
+	return columns
+}
+
+// policyColumns returns the columns which identify a policy rule.
+func (a *bunAdapter) policyColumns() []string {
+	return append([]string{"ptype"}, a.valueColumns()...)
+}
+
+func (a *bunAdapter) policyColumnIdents() []bun.Ident {
+	idents := make([]bun.Ident, 0, a.fieldCount+1)
+	for _, column := range a.policyColumns() {
+		idents = append(idents, bun.Ident(column))
+	}
+	return idents
+}
+
+// ruleColumns returns the policy columns followed by the width of the rule,
+// which are stored in the policy table, the history table and the table of the snapshot rules.
+func (a *bunAdapter) ruleColumns() []string {
+	return append(a.policyColumns(), ruleWidthColumn)
+}
+
+func (a *bunAdapter) ruleColumnIdents() []bun.Ident {
+	return append(a.policyColumnIdents(), bun.Ident(ruleWidthColumn))
+}
+
+// extraColumns returns the value columns which are not defined in CasbinPolicy.
+func (a *bunAdapter) extraColumns() []string {
+	return a.valueColumns()[defaultFieldCount:]
+}
+
+// createTable creates the table of the initial schema unless it exists.
+func (a *bunAdapter) createTable(ctx context.Context) error {
+	return a.createTableIfNotExists(ctx, a.createTableQuery(), a.tableName)
+}
+
+// createTableIfNotExists runs the query to create the table unless it exists.
+// bun drops IF NOT EXISTS on the dialects which do not support it (mssql), so the catalog is checked instead.
+func (a *bunAdapter) createTableIfNotExists(ctx context.Context, query *bun.CreateTableQuery, tableName string) error {
+	if !a.db.HasFeature(feature.TableNotExists) {
+		exists, err := a.tableExists(ctx, tableName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+	}
+	if _, err := query.Exec(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a *bunAdapter) createTableQuery() *bun.CreateTableQuery {
+	query := a.db.NewCreateTable().
+		Model((*policyTable)(nil)).
+		ModelTableExpr(a.tableExpr()).
+		IfNotExists()
+	for _, column := range a.valueColumns() {
+		query = query.ColumnExpr("? ?", bun.Ident(column), bun.Safe(a.columnType))
+	}
+	query = query.ColumnExpr("? ?", bun.Ident(ruleWidthColumn), bun.Safe(ruleWidthType))
+	if a.hashRules {
+		query = query.ColumnExpr("? ?", bun.Ident(ruleHashColumn), bun.Safe(ruleHashType))
+	}
+	return query
+}
+
+// prepareTable migrates the table to the latest schema version,
+// and then adds and widens the value columns for the field count and the column type,
+// which depend on the options rather than the schema version.
+// It holds the migration lock, so that the adapters starting at once do not alter the tables together.
+func (a *bunAdapter) prepareTable(ctx context.Context) error {
+	return a.withMigrationLock(ctx, func(ctx context.Context) error {
+		if err := a.migrate(ctx); err != nil {
+			return err
+		}
+		// the rules stay hashed once the table has the hash column, whatever the options,
+		// and the index of a table which starts hashing them is moved to the hash
+		hashed, err := a.columnExists(ctx, a.tableName, ruleHashColumn)
+		if err != nil {
+			return err
+		}
+		rehash := a.hashRules && !hashed
+		a.hashRules = a.hashRules || hashed
+		if a.audit {
+			if err := a.createAuditTable(ctx); err != nil {
+				return err
+			}
+		}
+		if a.history {
+			if err := a.createHistoryTable(ctx); err != nil {
+				return err
+			}
+			if _, err := a.addMissingColumns(ctx, a.historyTableName()); err != nil {
+				return err
+			}
+			if err := a.addRuleWidthColumn(ctx, a.historyTableName()); err != nil {
+				return err
+			}
+			if _, err := a.widenColumns(ctx, a.historyTableName()); err != nil {
+				return err
+			}
+			if err := a.startHistory(ctx); err != nil {
+				return err
+			}
+		}
+		if a.changeLog {
+			if err := a.createChangeLogTable(ctx); err != nil {
+				return err
+			}
+		}
+		if a.snapshots {
+			if err := a.createSnapshotTables(ctx); err != nil {
+				return err
+			}
+			if _, err := a.addMissingColumns(ctx, a.snapshotRuleTableName()); err != nil {
+				return err
+			}
+			if err := a.addRuleWidthColumn(ctx, a.snapshotRuleTableName()); err != nil {
+				return err
+			}
+			if _, err := a.widenColumns(ctx, a.snapshotRuleTableName()); err != nil {
+				return err
+			}
+		}
+
+		added, err := a.addMissingColumns(ctx, a.tableName)
+		if err != nil {
+			return err
+		}
+		widened, err := a.widenColumns(ctx, a.tableName)
+		if err != nil {
+			return err
+		}
+		if err := a.limitColumnLength(ctx); err != nil {
+			return err
+		}
+		return a.createUniqueIndex(ctx, added || widened || rehash)
+	})
+}
+
+// inspectTable reads the schema of the table created in advance for WithSkipTableCreation, without changing it,
+// so that the rules are hashed when the table has the hash column and the values are checked against the actual columns.
+// The options are kept when the table does not exist yet. The empty history table is started, which only writes rows.
+func (a *bunAdapter) inspectTable(ctx context.Context) error {
+	exists, err := a.tableExists(ctx, a.tableName)
+	if err != nil || !exists {
+		return err
+	}
+	if a.hashRules, err = a.columnExists(ctx, a.tableName, ruleHashColumn); err != nil {
+		return err
+	}
+	if err := a.limitColumnLength(ctx); err != nil {
+		return err
+	}
+	if a.history {
+		exists, err := a.tableExists(ctx, a.historyTableName())
+		if err != nil || !exists {
+			return err
+		}
+		return a.startHistory(ctx)
+	}
+	return nil
+}
+
+// addMissingColumns adds the extra value columns to the table created with a smaller field count,
+// which is the policy table, the history table or the table of the snapshot rules. It reports whether any column has been added.
+func (a *bunAdapter) addMissingColumns(ctx context.Context, tableName string) (bool, error) {
+	added := false
+	for _, column := range a.extraColumns() {
+		exists, err := a.columnExists(ctx, tableName, column)
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			continue
+		}
+		if _, err := a.db.NewAddColumn().
+			ModelTableExpr("?", bun.Ident(tableName)).
+			ColumnExpr("? ?", bun.Ident(column), bun.Safe(a.columnType)).
+			Exec(ctx); err != nil {
+			return false, err
+		}
+		// the stored rows get the empty value written for the unused columns, so that they are matched exactly
+		if _, err := a.db.NewUpdate().
+			ModelTableExpr("?", bun.Ident(tableName)).
+			Set("? = ?", bun.Ident(column), "").
+			Where("1 = 1").
+			Exec(ctx); err != nil {
+			return false, err
+		}
+		added = true
+	}
+	return added, nil
+}
+
+// addRuleWidthColumn adds the column of the width of the rules to the table created before it was recorded.
+// The width of the rows stored before is left NULL, and their trailing empty values are dropped as before.
+func (a *bunAdapter) addRuleWidthColumn(ctx context.Context, tableName string) error {
+	exists, err := a.columnExists(ctx, tableName, ruleWidthColumn)
+	if err != nil || exists {
+		return err
+	}
+	_, err = a.db.NewAddColumn().
+		ModelTableExpr("?", bun.Ident(tableName)).
+		ColumnExpr("? ?", bun.Ident(ruleWidthColumn), bun.Safe(ruleWidthType)).
+		Exec(ctx)
+	return err
+}
+
+// widenColumns alters the value columns of the table which are shorter than the column type to the type,
+// so that WithColumnLength and WithColumnType also apply to the tables created before.
+// The columns are never narrowed, since the stored values would be truncated. It reports whether any column has been altered.
+// The unique index of the policy table is dropped first, since mssql refuses to alter the indexed columns
+// and mysql to exceed the key length, and the caller recreates it.
+func (a *bunAdapter) widenColumns(ctx context.Context, tableName string) (bool, error) {
+	length, bounded := a.columnTypeLength()
+	if !bounded && boundedTypePattern.MatchString(a.columnType) {
+		// the length of the type is not known, so the columns are left as they are
+		return false, nil
+	}
+
+	var statement string
+	switch a.db.Dialect().Name() {
+	case dialect.MySQL:
+		statement = "ALTER TABLE ? MODIFY COLUMN ? ?"
+	case dialect.PG:
+		statement = "ALTER TABLE ? ALTER COLUMN ? TYPE ?"
+	case dialect.MSSQL:
+		statement = "ALTER TABLE ? ALTER COLUMN ? ?"
+	default:
+		// sqlite does not limit the length of the values
+		return false, nil
+	}
+
+	narrow := make([]string, 0)
+	for _, column := range a.valueColumns() {
+		current, err := a.columnLengthOf(ctx, tableName, column)
+		if err != nil {
+			return false, err
+		}
+		if current > 0 && (!bounded || current < length) {
+			narrow = append(narrow, column)
+		}
+	}
+	if len(narrow) == 0 {
+		return false, nil
+	}
+
+	if tableName == a.tableName {
+		exists, err := a.indexExists(ctx, a.uniqueIndexName())
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			if err := a.dropIndex(ctx, a.uniqueIndexName()); err != nil {
+				return false, err
+			}
+		}
+	}
+	for _, column := range narrow {
+		if _, err := a.db.ExecContext(ctx, statement, bun.Ident(tableName), bun.Ident(column), bun.Safe(a.columnType)); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// limitColumnLength lowers the maximum length of the values to the shortest value column of the policy table,
+// when the columns are shorter than the options tell, so that the values are checked against the actual columns.
+func (a *bunAdapter) limitColumnLength(ctx context.Context) error {
+	for _, column := range a.valueColumns() {
+		length, err := a.columnLengthOf(ctx, a.tableName, column)
+		if err != nil {
+			return err
+		}
+		if length > 0 && (a.columnLength == 0 || length < a.columnLength) {
+			a.columnLength = length
+		}
+	}
+	return nil
+}
+
+// columnTypeLength returns the length of the column type, or the maximum length of the values
+// when the type has no length such as text. It reports false when the length is unlimited.
+func (a *bunAdapter) columnTypeLength() (int, bool) {
+	if length, _, ok := charTypeLength(a.columnType); ok {
+		return length, true
+	}
+	return a.columnLength, a.columnLength > 0
+}
+
+// columnLengthOf returns the length in characters of the character column of the table,
+// which is zero when the length is unlimited, such as text, nvarchar(max) and any column on sqlite.
+func (a *bunAdapter) columnLengthOf(ctx context.Context, tableName, columnName string) (int, error) {
+	var query string
+	switch a.db.Dialect().Name() {
+	case dialect.MySQL:
+		query = "SELECT CASE WHEN data_type IN ('char', 'varchar') THEN character_maximum_length ELSE 0 END " +
+			"FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?"
+	case dialect.PG:
+		query = "SELECT CASE WHEN data_type IN ('character', 'character varying') THEN COALESCE(character_maximum_length, 0) ELSE 0 END " +
+			"FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?"
+	case dialect.MSSQL:
+		// max_length is in bytes, and -1 for the max types
+		query = "SELECT CASE WHEN max_length <= 0 THEN 0 WHEN TYPE_NAME(user_type_id) IN ('char', 'varchar') THEN max_length " +
+			"WHEN TYPE_NAME(user_type_id) IN ('nchar', 'nvarchar') THEN max_length / 2 ELSE 0 END " +
+			"FROM sys.columns WHERE object_id = OBJECT_ID(?) AND name = ?"
+	case dialect.SQLite:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("%w: unsupported dialect %s", ErrUnsupportedDriver, a.db.Dialect().Name())
+	}
+
+	var length sql.NullInt64
+	if err := a.db.NewRaw(query, tableName, columnName).Scan(ctx, &length); err != nil {
+		return 0, err
+	}
+	return int(length.Int64), nil
+}
+
+// tableExists checks if the table exists.
+func (a *bunAdapter) tableExists(ctx context.Context, tableName string) (bool, error) {
+	var query string
+	switch a.db.Dialect().Name() {
+	case dialect.MySQL:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	case dialect.PG:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	case dialect.MSSQL:
+		query = "SELECT COUNT(*) FROM sys.tables WHERE object_id = OBJECT_ID(?)"
+	case dialect.SQLite:
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	default:
+		return false, fmt.Errorf("%w: unsupported dialect %s", ErrUnsupportedDriver, a.db.Dialect().Name())
+	}
+
+	var count int
+	if err := a.db.NewRaw(query, tableName).Scan(ctx, &count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// columnExists checks if the column exists on the table.
+func (a *bunAdapter) columnExists(ctx context.Context, tableName, columnName string) (bool, error) {
+	var query string
+	switch a.db.Dialect().Name() {
+	case dialect.MySQL:
+		query = "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?"
+	case dialect.PG:
+		query = "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?"
+	case dialect.MSSQL:
+		query = "SELECT COUNT(*) FROM sys.columns WHERE object_id = OBJECT_ID(?) AND name = ?"
+	case dialect.SQLite:
+		query = "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?"
+	default:
+		return false, fmt.Errorf("%w: unsupported dialect %s", ErrUnsupportedDriver, a.db.Dialect().Name())
+	}
+
+	var count int
+	if err := a.db.NewRaw(query, tableName, columnName).Scan(ctx, &count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (a *bunAdapter) uniqueIndexName() string {
+	return fmt.Sprintf("%s_unique_index", a.tableName)
+}
+
+// canCreateUniqueIndex reports whether the policy columns fit in the index key length limit of the dialect.
+// mysql, postgres and mssql cannot index the columns of the types without a length, such as text and nvarchar(max),
+// so the rules are hashed into ruleHashColumn, which is indexed instead.
+// postgres only limits the size of the actual entries, which validateLengths checks for the types with a length.
+func (a *bunAdapter) canCreateUniqueIndex() bool {
+	length, bytesPerChar, ok := charTypeLength(a.columnType)
+	switch a.db.Dialect().Name() {
+	case dialect.MySQL:
+		return ok && (ptypeColumnLength+a.fieldCount*length)*mysqlBytesPerChar <= mysqlMaxKeyLength
+	case dialect.PG:
+		return ok
+	case dialect.MSSQL:
+		return ok && ptypeColumnLength+a.fieldCount*length*bytesPerChar <= mssqlMaxKeyLength
+	default:
+		return true
+	}
+}
+
+// pgIndexEntrySize returns the size in bytes of the btree index entry of postgres on the values,
+// counting the longest header of each value and its alignment, so that it never underestimates the size.
+func pgIndexEntrySize(values []string) int {
+	size := pgIndexTupleHeader
+	for _, value := range values {
+		size += (4 + len(value) + 3) / 4 * 4
+	}
+	return size
+}
+
+// charTypeLength returns the length of the character type and the bytes per character on mssql,
+// which is two for the national character types such as nvarchar.
+// It reports false when the type has no length, such as text.
+func charTypeLength(columnType string) (length int, bytesPerChar int, ok bool) {
+	match := charTypePattern.FindStringSubmatch(columnType)
+	if match == nil {
+		return 0, 0, false
+	}
+	length, err := strconv.Atoi(match[2])
+	if err != nil {
+		return 0, 0, false
+	}
+	bytesPerChar = 1
+	if match[1] != "" {
+		bytesPerChar = 2
+	}
+	return length, bytesPerChar, true
+}
+
+// createUniqueIndex creates the unique index on the policy columns unless it already exists,
+// or on the hash of the rules when the adapter hashes them, filling the hash column of the stored rows first.
+// The index is recreated when recreate is true, which is the case when columns have been added to the table.
+// Duplicate rows stored before the index existed fail the creation, unless WithRemoveDuplicates removes them first.
+func (a *bunAdapter) createUniqueIndex(ctx context.Context, recreate bool) error {
+	exists, err := a.indexExists(ctx, a.uniqueIndexName())
+	if err != nil {
+		return err
+	}
+	if exists {
+		if !recreate {
+			return nil
+		}
+		if err := a.dropIndex(ctx, a.uniqueIndexName()); err != nil {
+			return err
+		}
+	}
+
+	if a.hashRules {
+		if err := a.fillRuleHashes(ctx); err != nil {
+			return err
+		}
+	}
+
+	if a.removeDuplicates {
+		if err := a.removeDuplicateRows(ctx); err != nil {
+			return err
+		}
+	} else if err := a.checkDuplicateRows(ctx); err != nil {
+		return err
+	}
+
+	if _, err := a.createUniqueIndexQuery().Exec(ctx); err != nil {
+		// the index created by another adapter meanwhile, which is not locked out on sqlite, is not an error
+		if exists, existsErr := a.indexExists(ctx, a.uniqueIndexName()); existsErr == nil && exists {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (a *bunAdapter) createUniqueIndexQuery() *bun.CreateIndexQuery {
+	return a.db.NewCreateIndex().
+		TableExpr(a.tableExpr()).
+		Unique().
+		Index(a.uniqueIndexName()).
+		Column(a.uniqueColumns()...)
+}
+
+// uniqueColumns returns the columns of the unique index, which identify a policy rule.
+func (a *bunAdapter) uniqueColumns() []string {
+	if a.hashRules {
+		return []string{ruleHashColumn}
+	}
+	return a.policyColumns()
+}
+
+// fillRuleHashes adds the hash column to the table unless it exists, and sets the hashes of all the stored rows,
+// since the hashes depend on the field count and the rows may have been written without them.
+func (a *bunAdapter) fillRuleHashes(ctx context.Context) error {
+	exists, err := a.columnExists(ctx, a.tableName, ruleHashColumn)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if _, err := a.db.NewAddColumn().
+			ModelTableExpr(a.tableExpr()).
+			ColumnExpr("? ?", bun.Ident(ruleHashColumn), bun.Safe(ruleHashType)).
+			Exec(ctx); err != nil {
+			return err
+		}
+	}
+
+	rows, err := a.db.NewSelect().
+		TableExpr(a.tableExpr()).
+		Column("id").
+		Column(a.ruleColumns()...).
+		Rows(ctx)
+	if err != nil {
+		return err
+	}
+	policies := make([]CasbinPolicy, 0)
+	if err := a.scanRows(rows, func(policy CasbinPolicy) error {
+		policies = append(policies, policy)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return a.runInTx(ctx, func(ctx context.Context, tx bun.Tx) error {
+		for _, policy := range policies {
+			if _, err := tx.NewUpdate().
+				ModelTableExpr(a.tableExpr()).
+				Set("? = ?", bun.Ident(ruleHashColumn), a.ruleHash(policy)).
+				Where("id = ?", policy.ID).
+				Exec(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// indexExists checks if the index exists on the table.
+// Only postgres and sqlite support CREATE INDEX IF NOT EXISTS, so the catalog of each dialect is queried instead.
+func (a *bunAdapter) indexExists(ctx context.Context, indexName string) (bool, error) {
+	var query string
+	switch a.db.Dialect().Name() {
+	case dialect.MySQL:
+		query = "SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?"
+	case dialect.PG:
+		query = "SELECT COUNT(*) FROM pg_indexes WHERE schemaname = current_schema() AND tablename = ? AND indexname = ?"
+	case dialect.MSSQL:
+		query = "SELECT COUNT(*) FROM sys.indexes WHERE object_id = OBJECT_ID(?) AND name = ?"
+	case dialect.SQLite:
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND name = ?"
+	default:
+		return false, fmt.Errorf("%w: unsupported dialect %s", ErrUnsupportedDriver, a.db.Dialect().Name())
+	}
+
+	var count int
+	if err := a.db.NewRaw(query, a.tableName, indexName).Scan(ctx, &count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// dropIndex drops the index on the table.
+// mysql and mssql require the table name, which bun's DropIndexQuery does not support.
+func (a *bunAdapter) dropIndex(ctx context.Context, indexName string) error {
+	var err error
+	switch a.db.Dialect().Name() {
+	case dialect.MySQL, dialect.MSSQL:
+		_, err = a.db.ExecContext(ctx, "DROP INDEX ? ON ?", bun.Ident(indexName), bun.Ident(a.tableName))
+	default:
+		_, err = a.db.ExecContext(ctx, "DROP INDEX ?", bun.Ident(indexName))
+	}
+	return err
+}
+
+// checkDuplicateRows fails with ErrDuplicatePolicy naming the rules stored more than once,
+// which would otherwise fail the creation of the unique index with a dialect-specific error.
+func (a *bunAdapter) checkDuplicateRows(ctx context.Context) error {
+	duplicates, err := a.selectPolicies(ctx, a.db.NewSelect().Where(
+		"id NOT IN (SELECT MIN(id) FROM ? GROUP BY ?)",
+		bun.Ident(a.tableName),
+		bun.In(a.uniqueColumnIdents()),
+	))
+	if err != nil {
+		return err
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+	rules := make([][]string, 0, len(duplicates))
+	for _, policy := range duplicates {
+		rules = append(rules, policy.toSlice())
+	}
+	return fmt.Errorf("%w: the table stores the rules %v more than once, remove them or use WithRemoveDuplicates", ErrDuplicatePolicy, rules)
+}
+
+// removeDuplicateRows keeps only the oldest row of each policy rule.
+// The subquery is wrapped in a derived table because mysql cannot delete from a table selected in the subquery.
+func (a *bunAdapter) removeDuplicateRows(ctx context.Context) error {
+	if _, err := a.db.ExecContext(
+		ctx,
+		"DELETE FROM ? WHERE id NOT IN (SELECT id FROM (SELECT MIN(id) AS id FROM ? GROUP BY ?) AS kept)",
+		bun.Ident(a.tableName),
+		bun.Ident(a.tableName),
+		bun.In(a.uniqueColumnIdents()),
+	); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a *bunAdapter) uniqueColumnIdents() []bun.Ident {
+	idents := make([]bun.Ident, 0, a.fieldCount+1)
+	for _, column := range a.uniqueColumns() {
+		idents = append(idents, bun.Ident(column))
+	}
+	return idents
+}
+
+// SchemaSQL returns the statements which create the policy table and its unique index for the driver,
+// followed by the tables of WithAudit, WithHistory, WithChangeLog and WithSnapshots,
+// so that they can be applied by a database administrator when the adapter is used with WithSkipTableCreation.
+// The options such as WithTableName and WithFieldCount are reflected in the statements.
+// No connection is made to the database.
+func SchemaSQL(driverName string, opts ...adapterOption) ([]string, error) {
+	d, err := lookupDriver(driverName)
+	if err != nil {
+		return nil, err
+	}
+	a := &bunAdapter{
+		db:           bun.NewDB(sql.OpenDB(offlineConnector{}), offlineDialect{d.dialect()}),
+		tableName:    defaultTableName,
+		fieldCount:   defaultFieldCount,
+		columnType:   defaultColumnType,
+		columnLength: defaultColumnLength,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.hashRules = !a.canCreateUniqueIndex()
+
+	queries := []schema.QueryAppender{a.createTableQuery(), a.createUniqueIndexQuery()}
+	if a.audit {
+		queries = append(queries, a.createAuditTableQuery())
+	}
+	if a.history {
+		// the history starts with the policies stored when the first adapter is created
+		queries = append(queries, a.createHistoryTableQuery())
+	}
+	if a.changeLog {
+		queries = append(queries, a.createChangeLogTableQuery())
+	}
+	if a.snapshots {
+		queries = append(queries, a.createSnapshotTableQuery(), a.createSnapshotRuleTableQuery())
+	}
+
+	statements := make([]string, 0, len(queries))
+	for _, query := range queries {
+		b, err := query.AppendQuery(a.db.Formatter(), nil)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, string(b))
+	}
+	return statements, nil
+}
+
+// offlineDialect skips the initialization of the dialect, which queries the version of the database.
+type offlineDialect struct {
+	schema.Dialect
+}
+
+func (offlineDialect) Init(*sql.DB) {}
+
+// offlineConnector is the connector of the database which is never connected.
+type offlineConnector struct{}
+
+func (offlineConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, errors.New("offline database")
+}
+
+func (c offlineConnector) Driver() driver.Driver {
+	return offlineDriver{}
+}
+
+type offlineDriver struct{}
+
+func (offlineDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("offline database")
+}
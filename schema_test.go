@@ -0,0 +1,163 @@
+package casbinbunadapter
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func TestSchemaSQL(t *testing.T) {
+	tests := []struct {
+		name       string
+		driverName string
+		opts       []adapterOption
+		want       []string
+	}{
+		{
+			name:       "postgres",
+			driverName: "postgres",
+			want: []string{
+				`CREATE TABLE IF NOT EXISTS "casbin_policies" ("id" BIGSERIAL NOT NULL, "ptype" varchar(100) NOT NULL, "v0" varchar(100), "v1" varchar(100), "v2" varchar(100), "v3" varchar(100), "v4" varchar(100), "v5" varchar(100), "rule_width" integer, PRIMARY KEY ("id"))`,
+				`CREATE UNIQUE INDEX "casbin_policies_unique_index" ON "casbin_policies" ("ptype", "v0", "v1", "v2", "v3", "v4", "v5")`,
+			},
+		},
+		{
+			name:       "sqlite with table name and field count",
+			driverName: "sqlite3",
+			opts:       []adapterOption{WithTableName("rules"), WithFieldCount(7)},
+			want: []string{
+				`CREATE TABLE IF NOT EXISTS "rules" ("id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, "ptype" varchar(100) NOT NULL, "v0" varchar(100), "v1" varchar(100), "v2" varchar(100), "v3" varchar(100), "v4" varchar(100), "v5" varchar(100), "v6" varchar(100), "rule_width" integer)`,
+				`CREATE UNIQUE INDEX "rules_unique_index" ON "rules" ("ptype", "v0", "v1", "v2", "v3", "v4", "v5", "v6")`,
+			},
+		},
+		{
+			name:       "sqlite with an empty table name",
+			driverName: "sqlite3",
+			opts:       []adapterOption{WithTableName("")},
+			want: []string{
+				`CREATE TABLE IF NOT EXISTS "casbin_policies" ("id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, "ptype" varchar(100) NOT NULL, "v0" varchar(100), "v1" varchar(100), "v2" varchar(100), "v3" varchar(100), "v4" varchar(100), "v5" varchar(100), "rule_width" integer)`,
+				`CREATE UNIQUE INDEX "casbin_policies_unique_index" ON "casbin_policies" ("ptype", "v0", "v1", "v2", "v3", "v4", "v5")`,
+			},
+		},
+		{
+			name:       "mysql with the hash index exceeding the key length",
+			driverName: "mysql",
+			opts:       []adapterOption{WithFieldCount(8)},
+			want: []string{
+				"CREATE TABLE IF NOT EXISTS `casbin_policies` (`id` BIGINT NOT NULL AUTO_INCREMENT, `ptype` varchar(100) NOT NULL, `v0` varchar(100), `v1` varchar(100), `v2` varchar(100), `v3` varchar(100), `v4` varchar(100), `v5` varchar(100), `v6` varchar(100), `v7` varchar(100), `rule_width` integer, `rule_hash` char(64), PRIMARY KEY (`id`))",
+				"CREATE UNIQUE INDEX `casbin_policies_unique_index` ON `casbin_policies` (`rule_hash`)",
+			},
+		},
+		{
+			name:       "mssql with the hash index on nvarchar(max)",
+			driverName: "mssql",
+			opts:       []adapterOption{WithColumnType("nvarchar(max)", 0)},
+			want: []string{
+				`CREATE TABLE "casbin_policies" ("id" BIGINT NOT NULL IDENTITY, "ptype" varchar(100) NOT NULL, "v0" nvarchar(max), "v1" nvarchar(max), "v2" nvarchar(max), "v3" nvarchar(max), "v4" nvarchar(max), "v5" nvarchar(max), "rule_width" integer, "rule_hash" char(64), PRIMARY KEY ("id"))`,
+				`CREATE UNIQUE INDEX "casbin_policies_unique_index" ON "casbin_policies" ("rule_hash")`,
+			},
+		},
+		{
+			name:       "postgres with column length",
+			driverName: "postgres",
+			opts:       []adapterOption{WithColumnLength(255)},
+			want: []string{
+				`CREATE TABLE IF NOT EXISTS "casbin_policies" ("id" BIGSERIAL NOT NULL, "ptype" varchar(100) NOT NULL, "v0" varchar(255), "v1" varchar(255), "v2" varchar(255), "v3" varchar(255), "v4" varchar(255), "v5" varchar(255), "rule_width" integer, PRIMARY KEY ("id"))`,
+				`CREATE UNIQUE INDEX "casbin_policies_unique_index" ON "casbin_policies" ("ptype", "v0", "v1", "v2", "v3", "v4", "v5")`,
+			},
+		},
+		{
+			name:       "postgres with the hash index on text",
+			driverName: "postgres",
+			opts:       []adapterOption{WithColumnType("text", 0)},
+			want: []string{
+				`CREATE TABLE IF NOT EXISTS "casbin_policies" ("id" BIGSERIAL NOT NULL, "ptype" varchar(100) NOT NULL, "v0" text, "v1" text, "v2" text, "v3" text, "v4" text, "v5" text, "rule_width" integer, "rule_hash" char(64), PRIMARY KEY ("id"))`,
+				`CREATE UNIQUE INDEX "casbin_policies_unique_index" ON "casbin_policies" ("rule_hash")`,
+			},
+		},
+		{
+			name:       "mysql with history",
+			driverName: "mysql",
+			opts:       []adapterOption{WithHistory()},
+			want: []string{
+				"CREATE TABLE IF NOT EXISTS `casbin_policies` (`id` BIGINT NOT NULL AUTO_INCREMENT, `ptype` varchar(100) NOT NULL, `v0` varchar(100), `v1` varchar(100), `v2` varchar(100), `v3` varchar(100), `v4` varchar(100), `v5` varchar(100), `rule_width` integer, PRIMARY KEY (`id`))",
+				"CREATE UNIQUE INDEX `casbin_policies_unique_index` ON `casbin_policies` (`ptype`, `v0`, `v1`, `v2`, `v3`, `v4`, `v5`)",
+				"CREATE TABLE IF NOT EXISTS `casbin_policies_history` (`id` BIGINT NOT NULL AUTO_INCREMENT, `ptype` varchar(100) NOT NULL, `v0` varchar(100), `v1` varchar(100), `v2` varchar(100), `v3` varchar(100), `v4` varchar(100), `v5` varchar(100), `rule_width` integer, valid_from datetime(6) NOT NULL, valid_to datetime(6), PRIMARY KEY (`id`))",
+			},
+		},
+		{
+			name:       "sqlite with change log",
+			driverName: "sqlite3",
+			opts:       []adapterOption{WithChangeLog()},
+			want: []string{
+				`CREATE TABLE IF NOT EXISTS "casbin_policies" ("id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, "ptype" varchar(100) NOT NULL, "v0" varchar(100), "v1" varchar(100), "v2" varchar(100), "v3" varchar(100), "v4" varchar(100), "v5" varchar(100), "rule_width" integer)`,
+				`CREATE UNIQUE INDEX "casbin_policies_unique_index" ON "casbin_policies" ("ptype", "v0", "v1", "v2", "v3", "v4", "v5")`,
+				`CREATE TABLE IF NOT EXISTS "casbin_policies_change_log" ("id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, "instance" varchar(100) NOT NULL, "created_at" TIMESTAMP NOT NULL, message text NOT NULL)`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SchemaSQL(tt.driverName, tt.opts...)
+			if err != nil {
+				t.Fatalf("SchemaSQL() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("SchemaSQL() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_charTypeLength(t *testing.T) {
+	tests := []struct {
+		columnType       string
+		wantLength       int
+		wantBytesPerChar int
+		wantOK           bool
+	}{
+		{columnType: "varchar(100)", wantLength: 100, wantBytesPerChar: 1, wantOK: true},
+		{columnType: "VARCHAR (255)", wantLength: 255, wantBytesPerChar: 1, wantOK: true},
+		{columnType: "nvarchar(200)", wantLength: 200, wantBytesPerChar: 2, wantOK: true},
+		{columnType: "char(10)", wantLength: 10, wantBytesPerChar: 1, wantOK: true},
+		{columnType: "nvarchar(max)"},
+		{columnType: "text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.columnType, func(t *testing.T) {
+			length, bytesPerChar, ok := charTypeLength(tt.columnType)
+			if length != tt.wantLength || bytesPerChar != tt.wantBytesPerChar || ok != tt.wantOK {
+				t.Errorf("charTypeLength() = %d, %d, %v, want %d, %d, %v", length, bytesPerChar, ok, tt.wantLength, tt.wantBytesPerChar, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestBunAdapter_validateLengths_postgres(t *testing.T) {
+	a := &bunAdapter{
+		db:           bun.NewDB(sql.OpenDB(offlineConnector{}), offlineDialect{pgdialect.New()}),
+		fieldCount:   defaultFieldCount,
+		columnType:   "varchar(1000)",
+		columnLength: 1000,
+	}
+	long := strings.Repeat("a", 1000)
+
+	// each value fits in its column, but the index entry of the rule exceeds the limit of postgres
+	if err := a.validateLengths(newCasbinPolicy("p", []string{long, long, long})); !errors.Is(err, ErrInvalidRule) {
+		t.Errorf("validateLengths() error = %v, want %v", err, ErrInvalidRule)
+	}
+	if err := a.validateLengths(newCasbinPolicy("p", []string{long, long, "read"})); err != nil {
+		t.Errorf("validateLengths() error = %v, want nil", err)
+	}
+
+	// the hash of the rule is indexed instead of the values
+	a.hashRules = true
+	if err := a.validateLengths(newCasbinPolicy("p", []string{long, long, long})); err != nil {
+		t.Errorf("validateLengths() error = %v, want nil", err)
+	}
+}
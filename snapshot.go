@@ -0,0 +1,231 @@
+package casbinbunadapter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// SnapshotInfo is a named snapshot of the policy table taken by Snapshot.
+type SnapshotInfo struct {
+	bun.BaseModel `bun:"casbin_policies_snapshots,alias:sn"`
+	ID            int64  `bun:"id,pk,autoincrement"`
+	Name          string `bun:"name,type:varchar(255),notnull,unique"`
+	// Rules is the number of the policy rules in the snapshot.
+	Rules     int       `bun:"rules,notnull"`
+	CreatedAt time.Time `bun:"created_at,notnull"`
+}
+
+// snapshotRuleTable is the model to create the table of the rules in the snapshots,
+// whose value columns are created like the policy table.
+type snapshotRuleTable struct {
+	bun.BaseModel `bun:"casbin_policies_snapshot_rules"`
+	ID            int64  `bun:"id,pk,autoincrement"`
+	SnapshotID    int64  `bun:"snapshot_id,notnull"`
+	PType         string `bun:"ptype,type:varchar(100),notnull"`
+}
+
+// WithSnapshots makes the adapter create the tables of the named snapshots of the policy,
+// which are taken with Snapshot and restored with RestoreSnapshot.
+func WithSnapshots() adapterOption {
+	return func(a *bunAdapter) {
+		a.snapshots = true
+	}
+}
+
+func (a *bunAdapter) snapshotTableName() string {
+	return a.tableName + "_snapshots"
+}
+
+func (a *bunAdapter) snapshotRuleTableName() string {
+	return a.tableName + "_snapshot_rules"
+}
+
+// createSnapshotTables creates the tables of the snapshots unless they exist.
+func (a *bunAdapter) createSnapshotTables(ctx context.Context) error {
+	if err := a.createTableIfNotExists(ctx, a.createSnapshotTableQuery(), a.snapshotTableName()); err != nil {
+		return err
+	}
+	return a.createTableIfNotExists(ctx, a.createSnapshotRuleTableQuery(), a.snapshotRuleTableName())
+}
+
+func (a *bunAdapter) createSnapshotTableQuery() *bun.CreateTableQuery {
+	return a.db.NewCreateTable().
+		Model((*SnapshotInfo)(nil)).
+		ModelTableExpr("?", bun.Ident(a.snapshotTableName())).
+		IfNotExists()
+}
+
+func (a *bunAdapter) createSnapshotRuleTableQuery() *bun.CreateTableQuery {
+	query := a.db.NewCreateTable().
+		Model((*snapshotRuleTable)(nil)).
+		ModelTableExpr("?", bun.Ident(a.snapshotRuleTableName())).
+		IfNotExists()
+	for _, column := range a.valueColumns() {
+		query = query.ColumnExpr("? ?", bun.Ident(column), bun.Safe(a.columnType))
+	}
+	return query.ColumnExpr("? ?", bun.Ident(ruleWidthColumn), bun.Safe(ruleWidthType))
+}
+
+// Snapshot copies all the stored policy rules into the snapshot of the name in a transaction.
+// It returns ErrDuplicateSnapshot when the snapshot of the name already exists.
+func (a *bunAdapter) Snapshot(ctx context.Context, name string) error {
+	if err := a.checkSnapshots(); err != nil {
+		return err
+	}
+
+	return a.runInTx(ctx, func(ctx context.Context, tx bun.Tx) error {
+		exists, err := tx.NewSelect().
+			TableExpr("?", bun.Ident(a.snapshotTableName())).
+			Where("name = ?", name).
+			Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("%w: %s", ErrDuplicateSnapshot, name)
+		}
+
+		info := SnapshotInfo{Name: name, CreatedAt: time.Now().UTC()}
+		if _, err := tx.NewInsert().
+			Model(&info).
+			ModelTableExpr("?", bun.Ident(a.snapshotTableName())).
+			Exec(ctx); err != nil {
+			if isUniqueViolation(err) {
+				// the snapshot of the same name has been taken concurrently
+				return fmt.Errorf("%w: %s", ErrDuplicateSnapshot, name)
+			}
+			return err
+		}
+
+		columns := bun.In(a.ruleColumnIdents())
+		res, err := tx.NewRaw(
+			"INSERT INTO ? (snapshot_id, ?) SELECT ?, ? FROM ?",
+			bun.Ident(a.snapshotRuleTableName()), columns, info.ID, columns, bun.Ident(a.tableName),
+		).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		rules, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewUpdate().
+			ModelTableExpr("?", bun.Ident(a.snapshotTableName())).
+			Set("rules = ?", rules).
+			Where("id = ?", info.ID).
+			Exec(ctx)
+		return err
+	})
+}
+
+// ListSnapshots returns the snapshots from the oldest.
+func (a *bunAdapter) ListSnapshots(ctx context.Context) ([]SnapshotInfo, error) {
+	if err := a.checkSnapshots(); err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]SnapshotInfo, 0)
+	if err := a.db.NewSelect().
+		Model(&snapshots).
+		ModelTableExpr("? AS sn", bun.Ident(a.snapshotTableName())).
+		Order("id").
+		Scan(ctx); err != nil {
+		return nil, wrapError(err)
+	}
+	return snapshots, nil
+}
+
+// RestoreSnapshot rewrites the stored policy rules to the ones in the snapshot of the name in a transaction,
+// only writing the difference like WithIncrementalSave. The restore is recorded as SavePolicy,
+// so the watchers reload the whole policy. It returns ErrSnapshotNotFound when the snapshot does not exist.
+func (a *bunAdapter) RestoreSnapshot(ctx context.Context, name string) error {
+	if err := a.checkSnapshots(); err != nil {
+		return err
+	}
+	if a.isFiltered.Load() {
+		return ErrFilteredPolicy
+	}
+
+	change := policyChange{method: UpdateForSavePolicy}
+	return a.writeInTx(ctx, &change, func(ctx context.Context, tx bun.Tx) error {
+		id, err := a.snapshotID(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.NewSelect().
+			TableExpr("?", bun.Ident(a.snapshotRuleTableName())).
+			Column("id").
+			Column(a.ruleColumns()...).
+			Where("snapshot_id = ?", id).
+			OrderExpr("? ASC", bun.Ident("id")).
+			Rows(ctx)
+		if err != nil {
+			return wrapError(err)
+		}
+		policies := make([]CasbinPolicy, 0)
+		if err := a.scanRows(rows, func(policy CasbinPolicy) error {
+			policies = append(policies, policy)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		_, err = a.applyDiff(ctx, tx, &change, policies)
+		return err
+	})
+}
+
+// DeleteSnapshot deletes the snapshot of the name and its rules in a transaction.
+// It returns ErrSnapshotNotFound when the snapshot does not exist.
+func (a *bunAdapter) DeleteSnapshot(ctx context.Context, name string) error {
+	if err := a.checkSnapshots(); err != nil {
+		return err
+	}
+
+	return a.runInTx(ctx, func(ctx context.Context, tx bun.Tx) error {
+		id, err := a.snapshotID(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.NewDelete().
+			TableExpr("?", bun.Ident(a.snapshotRuleTableName())).
+			Where("snapshot_id = ?", id).
+			Exec(ctx); err != nil {
+			return err
+		}
+		_, err = tx.NewDelete().
+			TableExpr("?", bun.Ident(a.snapshotTableName())).
+			Where("id = ?", id).
+			Exec(ctx)
+		return err
+	})
+}
+
+// snapshotID returns the id of the snapshot of the name, failing with ErrSnapshotNotFound when it does not exist.
+func (a *bunAdapter) snapshotID(ctx context.Context, db bun.IDB, name string) (int64, error) {
+	var id int64
+	err := db.NewSelect().
+		TableExpr("?", bun.Ident(a.snapshotTableName())).
+		Column("id").
+		Where("name = ?", name).
+		Scan(ctx, &id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+	}
+	return id, wrapError(err)
+}
+
+// checkSnapshots fails unless the adapter is created with WithSnapshots, since the tables may not exist.
+func (a *bunAdapter) checkSnapshots() error {
+	if !a.snapshots {
+		return fmt.Errorf("%w: the adapter is created without WithSnapshots", ErrSnapshotNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package casbinbunadapter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+var (
+	// check if the Watcher implements the WatcherEx interface
+	_ persist.WatcherEx = (*Watcher)(nil)
+	// check if the Watcher implements the UpdatableWatcher interface
+	_ persist.UpdatableWatcher = (*Watcher)(nil)
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultRetention    = 24 * time.Hour
+	// cleanupInterval is the interval of deleting the changes older than the retention.
+	cleanupInterval = time.Minute
+	// gapTimeout is how long a missing sequence number is waited for,
+	// since a transaction which started earlier may commit after a later one.
+	gapTimeout = 10 * time.Second
+	// maxGaps is the maximum number of the missing sequence numbers waited for.
+	maxGaps = 1000
+)
+
+// UpdateType is the kind of the change recorded in the change log.
+type UpdateType string
+
+const (
+	Update                          UpdateType = "Update"
+	UpdateForAddPolicy              UpdateType = "UpdateForAddPolicy"
+	UpdateForRemovePolicy           UpdateType = "UpdateForRemovePolicy"
+	UpdateForRemoveFilteredPolicy   UpdateType = "UpdateForRemoveFilteredPolicy"
+	UpdateForSavePolicy             UpdateType = "UpdateForSavePolicy"
+	UpdateForAddPolicies            UpdateType = "UpdateForAddPolicies"
+	UpdateForRemovePolicies         UpdateType = "UpdateForRemovePolicies"
+	UpdateForUpdatePolicy           UpdateType = "UpdateForUpdatePolicy"
+	UpdateForUpdatePolicies         UpdateType = "UpdateForUpdatePolicies"
+	UpdateForUpdateFilteredPolicies UpdateType = "UpdateForUpdateFilteredPolicies"
+)
+
+// WatcherMessage is the change passed to the update callback as JSON.
+type WatcherMessage struct {
+	Method UpdateType `json:"method"`
+	// ID is the ID of the watcher of the instance which made the change.
+	ID    string `json:"id"`
+	Sec   string `json:"sec,omitempty"`
+	PType string `json:"ptype,omitempty"`
+	// Rules are the rules added or removed, or the old rules of an update.
+	Rules [][]string `json:"rules,omitempty"`
+	// NewRules are the new rules of an update.
+	NewRules    [][]string `json:"new_rules,omitempty"`
+	FieldIndex  int        `json:"field_index,omitempty"`
+	FieldValues []string   `json:"field_values,omitempty"`
+}
+
+// changeLogEntry is a row of the change log table.
+// The message column is created separately, since its type depends on the dialect.
+type changeLogEntry struct {
+	bun.BaseModel `bun:"casbin_policies_change_log,alias:cl"`
+	ID            int64     `bun:"id,pk,autoincrement"`
+	Instance      string    `bun:"instance,type:varchar(100),notnull"`
+	CreatedAt     time.Time `bun:"created_at,notnull"`
+	Message       string    `bun:"message,scanonly"`
+}
+
+// Watcher is the persist.WatcherEx which notifies the other instances sharing the table of the changes of the policy.
+// The adapter appends every write to the change log table in the same transaction,
+// and the watcher of each instance polls the table for the changes made by the others.
+type Watcher struct {
+	recordedUpdates
+
+	adapter   *bunAdapter
+	id        string
+	interval  time.Duration
+	retention time.Duration
+
+	mu       sync.Mutex
+	callback func(string)
+	// lastID is the largest sequence number seen, and gaps are the missing ones below it with their deadlines.
+	lastID      int64
+	gaps        map[int64]time.Time
+	lastCleanup time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type watcherOption func(*Watcher)
+
+// WithPollInterval sets the interval of polling the change log, which is one second by default.
+func WithPollInterval(interval time.Duration) watcherOption {
+	return func(w *Watcher) {
+		if interval > 0 {
+			w.interval = interval
+		}
+	}
+}
+
+// WithRetention sets how long the changes are kept in the change log, which is 24 hours by default.
+// The changes are never deleted when it is zero.
+func WithRetention(retention time.Duration) watcherOption {
+	return func(w *Watcher) {
+		if retention >= 0 {
+			w.retention = retention
+		}
+	}
+}
+
+// NewWatcher creates the watcher of the adapter, and starts polling the change log.
+// The changes made before it is created are not notified.
+// Only one watcher can be attached to an adapter, and the previous one stops recording the changes.
+func NewWatcher(adapter persist.Adapter, opts ...watcherOption) (*Watcher, error) {
+	a, err := unwrapAdapter(adapter)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newInstanceID()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		adapter:   a,
+		id:        id,
+		interval:  defaultPollInterval,
+		retention: defaultRetention,
+		gaps:      make(map[int64]time.Time),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	ctx := context.Background()
+	if !a.skipTable {
+		if err := a.createChangeLogTable(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.db.NewSelect().
+		ModelTableExpr("?", bun.Ident(a.changeLogTableName())).
+		ColumnExpr("COALESCE(MAX(id), 0)").
+		Scan(ctx, &w.lastID); err != nil {
+		return nil, err
+	}
+
+	a.setWatcher(w)
+
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.done = make(chan struct{})
+	go w.run(ctx)
+
+	return w, nil
+}
+
+func newInstanceID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ID returns the ID of the watcher, which is set to WatcherMessage.ID of the changes made through its adapter.
+func (w *Watcher) ID() string {
+	return w.id
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.poll(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("casbinbunadapter: failed to poll the change log: %v", err)
+			}
+		}
+	}
+}
+
+// poll calls the update callback with the changes made by the other instances since the last poll,
+// and deletes the changes older than the retention.
+func (w *Watcher) poll(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	gaps := make([]int64, 0, len(w.gaps))
+	for id, deadline := range w.gaps {
+		if now.After(deadline) {
+			delete(w.gaps, id)
+			continue
+		}
+		gaps = append(gaps, id)
+	}
+
+	var entries []changeLogEntry
+	query := w.adapter.db.NewSelect().
+		Model(&entries).
+		ModelTableExpr("? AS cl", bun.Ident(w.adapter.changeLogTableName())).
+		Column("id", "instance", "message").
+		Where("id > ?", w.lastID).
+		Order("id")
+	if len(gaps) > 0 {
+		query = query.WhereOr("id IN (?)", bun.In(gaps))
+	}
+	if err := query.Scan(ctx); err != nil {
+		return wrapError(err)
+	}
+
+	for _, entry := range entries {
+		delete(w.gaps, entry.ID)
+		if entry.ID > w.lastID {
+			for id := w.lastID + 1; id < entry.ID && len(w.gaps) < maxGaps; id++ {
+				w.gaps[id] = now.Add(gapTimeout)
+			}
+			w.lastID = entry.ID
+		}
+		if entry.Instance != w.id && w.callback != nil {
+			w.callback(entry.Message)
+		}
+	}
+
+	if w.retention > 0 && now.Sub(w.lastCleanup) >= cleanupInterval {
+		if _, err := w.adapter.db.NewDelete().
+			TableExpr("?", bun.Ident(w.adapter.changeLogTableName())).
+			Where("created_at < ?", now.Add(-w.retention).UTC()).
+			Exec(ctx); err != nil {
+			return wrapError(err)
+		}
+		w.lastCleanup = now
+	}
+	return nil
+}
+
+// SetUpdateCallback sets the callback called with the WatcherMessage as JSON
+// when the policy has been changed by the other instances.
+// DefaultUpdateCallback applies the changes to a casbin.IDistributedEnforcer.
+func (w *Watcher) SetUpdateCallback(callback func(string)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callback = callback
+	return nil
+}
+
+// Update notifies the other instances that the policy has been changed, so that they reload the whole policy.
+func (w *Watcher) Update() error {
+	return w.recordChange(context.Background(), w.adapter.db, policyChange{method: Update})
+}
+
+// Close stops polling the change log, and stops recording the changes of the adapter.
+func (w *Watcher) Close() {
+	w.cancel()
+	<-w.done
+	w.adapter.unsetWatcher(w)
+}
+
+// recordedUpdates implements the UpdateFor methods of persist.WatcherEx and persist.UpdatableWatcher,
+// which do nothing since the adapter has recorded the change when the policy was written.
+type recordedUpdates struct{}
+
+func (recordedUpdates) UpdateForAddPolicy(sec, ptype string, params ...string) error {
+	return nil
+}
+
+func (recordedUpdates) UpdateForRemovePolicy(sec, ptype string, params ...string) error {
+	return nil
+}
+
+func (recordedUpdates) UpdateForRemoveFilteredPolicy(sec, ptype string, fieldIndex int, fieldValues ...string) error {
+	return nil
+}
+
+func (recordedUpdates) UpdateForSavePolicy(model model.Model) error {
+	return nil
+}
+
+func (recordedUpdates) UpdateForAddPolicies(sec string, ptype string, rules ...[]string) error {
+	return nil
+}
+
+func (recordedUpdates) UpdateForRemovePolicies(sec string, ptype string, rules ...[]string) error {
+	return nil
+}
+
+func (recordedUpdates) UpdateForUpdatePolicy(sec string, ptype string, oldRule, newRule []string) error {
+	return nil
+}
+
+func (recordedUpdates) UpdateForUpdatePolicies(sec string, ptype string, oldRules, newRules [][]string) error {
+	return nil
+}
+
+// DefaultUpdateCallback returns the update callback which applies the changes to the enforcer without writing them back to the storage.
+// The whole policy is reloaded on SavePolicy and Update.
+func DefaultUpdateCallback(e casbin.IDistributedEnforcer) func(string) {
+	return func(message string) {
+		var msg WatcherMessage
+		if err := json.Unmarshal([]byte(message), &msg); err != nil {
+			log.Printf("casbinbunadapter: failed to parse the watcher message: %v", err)
+			return
+		}
+
+		noPersist := func() bool { return false }
+		var err error
+		switch msg.Method {
+		case UpdateForAddPolicy, UpdateForAddPolicies:
+			_, err = e.AddPoliciesSelf(noPersist, msg.Sec, msg.PType, msg.Rules)
+		case UpdateForRemovePolicy, UpdateForRemovePolicies:
+			_, err = e.RemovePoliciesSelf(noPersist, msg.Sec, msg.PType, msg.Rules)
+		case UpdateForRemoveFilteredPolicy:
+			_, err = e.RemoveFilteredPolicySelf(noPersist, msg.Sec, msg.PType, msg.FieldIndex, msg.FieldValues...)
+		case UpdateForUpdatePolicy, UpdateForUpdatePolicies:
+			_, err = e.UpdatePoliciesSelf(noPersist, msg.Sec, msg.PType, msg.Rules, msg.NewRules)
+		case UpdateForUpdateFilteredPolicies:
+			_, err = e.UpdateFilteredPoliciesSelf(noPersist, msg.Sec, msg.PType, msg.NewRules, msg.FieldIndex, msg.FieldValues...)
+		default:
+			err = e.LoadPolicy()
+		}
+		if err != nil {
+			log.Printf("casbinbunadapter: failed to apply %s: %v", msg.Method, err)
+		}
+	}
+}
+
+// recordChange appends the change to the change log with db, which is the transaction of the write.
+func (w *Watcher) recordChange(ctx context.Context, db bun.IDB, change policyChange) error {
+	message, err := json.Marshal(change.message(w.id))
+	if err != nil {
+		return err
+	}
+
+	// the message column is not a field of changeLogEntry, so the query is built from the columns
+	if _, err := db.NewRaw(
+		"INSERT INTO ? (instance, created_at, message) VALUES (?, ?, ?)",
+		bun.Ident(w.adapter.changeLogTableName()), w.id, time.Now().UTC(), string(message),
+	).Exec(ctx); err != nil {
+		return wrapError(err)
+	}
+	return nil
+}
+
+func (a *bunAdapter) changeLogTableName() string {
+	return a.tableName + "_change_log"
+}
+
+// WithChangeLog makes the adapter create the change log table of NewWatcher along with the policy table,
+// and SchemaSQL return its statement, so that the watcher can be used with WithSkipTableCreation.
+func WithChangeLog() adapterOption {
+	return func(a *bunAdapter) {
+		a.changeLog = true
+	}
+}
+
+// createChangeLogTable creates the change log table unless it exists.
+func (a *bunAdapter) createChangeLogTable(ctx context.Context) error {
+	return a.createTableIfNotExists(ctx, a.createChangeLogTableQuery(), a.changeLogTableName())
+}
+
+func (a *bunAdapter) createChangeLogTableQuery() *bun.CreateTableQuery {
+	return a.db.NewCreateTable().
+		Model((*changeLogEntry)(nil)).
+		ModelTableExpr("?", bun.Ident(a.changeLogTableName())).
+		ColumnExpr("message ? NOT NULL", bun.Safe(a.textType())).
+		IfNotExists()
+}
+
+// textType returns the type of the columns of unlimited length.
+func (a *bunAdapter) textType() string {
+	if a.db.Dialect().Name() == dialect.MSSQL {
+		return "nvarchar(max)"
+	}
+	return "text"
+}
+
+// unwrapAdapter returns the bunAdapter of the adapter created by this package.
+func unwrapAdapter(adapter persist.Adapter) (*bunAdapter, error) {
+	switch a := adapter.(type) {
+	case *bunAdapter:
+		return a, nil
+	case *ctxBunAdapter:
+		return a.bunAdapter, nil
+	default:
+		return nil, fmt.Errorf("the adapter %T is not created by casbinbunadapter", adapter)
+	}
+}